@@ -0,0 +1,670 @@
+package audit
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"github.com/snechholt/bufrw"
+	"io"
+	"sync"
+)
+
+// Since version 2, values are serialized as a log of records that new history
+// entries can be appended to without rewriting the existing bytes:
+//
+//	version
+//	(entry record | key signature record)*
+//	footer record
+//	footer length
+//
+// Each entry record holds a history entry along with the names of the fields and
+// the auditors it is the first to use, which extend the field name and auditor
+// tables, and its checkpoint, if any. The signature of an entry is written as the
+// index of its auditor and the difference between its timestamp and the timestamp
+// of the entry before it, both as varints, followed by its metadata. The fields and
+// checkpoint of an entry, which make up most of the record, are compressed with
+// flate if value compression is enabled (see SetValueCompression) and it makes
+// them smaller. A key signature record holds the ed25519 signature of an entry
+// that was signed by SignLatest after its entry record was written.
+//
+// The footer holds the header (see ReadSerializedHeader), the hash of the latest
+// entry and whether it is signed, the complete field name and auditor tables, the
+// field names that are only used by entry markers, the snapshot of the latest state
+// and the settings. Since the footer ends with its length, it can be found from the
+// end of the bytes, and AppendSerialized replaces it when appending new entries.
+const (
+	recordTypeEntry        byte = 1
+	recordTypeFooter       byte = 2
+	recordTypeKeySignature byte = 3
+)
+
+// logVersion is the first format version using the log layout.
+const logVersion = 2
+
+// SetValueCompression sets whether the values of the history entries are
+// compressed when serialized, which reduces the size of histories with large or
+// repetitive values at the expense of the time it takes to serialize and
+// deserialize them. Compression is disabled by default. The setting is serialized
+// along with the history.
+func (values *AuditableValues) SetValueCompression(enabled bool) {
+	values.valueCompression = enabled
+}
+
+// ValueCompression returns the setting set with SetValueCompression.
+func (values *AuditableValues) ValueCompression() bool {
+	return values.valueCompression
+}
+
+// AppendSerialized appends the history entries that are not in prev, which must
+// hold the values as serialized by Serialize or AppendSerialized at an earlier
+// point, and returns the extended bytes. Only the new entries and the footer of
+// the serialized values are written, so the cost of persisting an audit does not
+// grow with the length of the history. If prev is empty, or in a format version
+// before the log layout, the values are serialized completely in the current
+// version instead.
+//
+// Like append, AppendSerialized may overwrite the footer of prev in place, so prev
+// must not be used after a successful call. If an error is returned, prev is left
+// unchanged. An error is returned if prev is in a format version that cannot be
+// appended to, or if the history in prev is not a prefix of the values, such as
+// after Compact or Merge. The values should then be serialized completely with
+// Serialize. If SignLatest signed the latest entry in
+// prev after prev was written, the signature is appended along with the new
+// entries.
+func (values *AuditableValues) AppendSerialized(prev []byte) ([]byte, error) {
+	if len(prev) == 0 {
+		return values.Serialize()
+	}
+	version := prev[0]
+	if version < 1 || version > serializationVersion {
+		return nil, fmt.Errorf("cannot append to values serialized in format version %d", version)
+	}
+	if version < serializationVersion {
+		return values.Serialize()
+	}
+	footer, footerStart, err := readFooterAt(prev)
+	if err != nil {
+		return nil, err
+	}
+	n := footer.header.EntryCount
+	if n > len(values.history) || (n > 0 && !bytes.Equal(values.history[n-1].hash, footer.latestHash)) {
+		return nil, fmt.Errorf("cannot append: the serialized history is not a prefix of the values")
+	}
+
+	// The records are written to a separate buffer first, so that prev is left intact
+	// if they cannot be encoded
+	var b bytes.Buffer
+	var buf bufrw.Buffer
+	w := buf.Writer(&b)
+	if n > 0 && !footer.latestSigned && values.history[n-1].keySignature != nil {
+		err := writeRecord(w, recordTypeKeySignature, func(w *bufrw.Writer) error {
+			if err := w.WriteInt(n - 1); err != nil {
+				return err
+			}
+			return w.WriteByteValues(values.history[n-1].keySignature...)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	tables := logTables{
+		fieldNames:  footer.fieldNames[:footer.recordNameCount],
+		markerNames: footer.markerNames,
+		auditors:    footer.auditors,
+	}
+	if err := values.writeLog(w, tables, n); err != nil {
+		return nil, err
+	}
+	return append(prev[:footerStart], b.Bytes()...), nil
+}
+
+// logTables holds the tables that the entry records refer to by index.
+type logTables struct {
+	fieldNames stringSlice
+	// markerNames holds the names in fieldNames that are only used by the entries
+	// marking the creation or truncation of the history (see isEntryMarker).
+	markerNames stringSlice
+	// auditors holds the encoded auditors.
+	auditors stringSlice
+}
+
+// serializeLogTo serializes the values in the log layout.
+func (values *AuditableValues) serializeLogTo(w *bufrw.Writer) error {
+	if err := w.WriteByteValue(serializationVersion); err != nil {
+		return err
+	}
+	return values.writeLog(w, logTables{}, 0)
+}
+
+// writeLog writes the entry records of the entries from index start, followed by
+// the footer. tables holds the tables defined by the records before start.
+func (values *AuditableValues) writeLog(w *bufrw.Writer, tables logTables, start int) error {
+	fieldNames := append(stringSlice(nil), tables.fieldNames...)
+	auditors := append(stringSlice(nil), tables.auditors...)
+	markerNames := append(stringSlice(nil), tables.markerNames...)
+	addFieldNames := func(fields fieldSlice) stringSlice {
+		var added stringSlice
+		for _, field := range fields {
+			if !fieldNames.Contains(field.Name) {
+				fieldNames = append(fieldNames, field.Name)
+				added = append(added, field.Name)
+				if field.isEntryMarker() {
+					markerNames = append(markerNames, field.Name)
+				}
+			}
+			if i := markerNames.IndexOf(field.Name); i >= 0 && !field.isEntryMarker() {
+				markerNames = append(markerNames[:i], markerNames[i+1:]...)
+			}
+		}
+		return added
+	}
+
+	nextCheckpoint := 0
+	for nextCheckpoint < len(values.checkpoints) && values.checkpoints[nextCheckpoint].index < start {
+		nextCheckpoint++
+	}
+	var prevUnixNano int64
+	if start > 0 {
+		var err error
+		if prevUnixNano, err = values.history[start-1].signature.unixNano(); err != nil {
+			return err
+		}
+	}
+	for i := start; i < len(values.history); i++ {
+		h := values.history[i]
+		var cp *checkpoint
+		if nextCheckpoint < len(values.checkpoints) && values.checkpoints[nextCheckpoint].index == i {
+			cp = &values.checkpoints[nextCheckpoint]
+			nextCheckpoint++
+		}
+		added := addFieldNames(h.fields)
+		if cp != nil {
+			added = append(added, addFieldNames(cp.fields)...)
+		}
+		writeValues := func(w *bufrw.Writer) error {
+			if err := writeFields(w, h.fields, fieldNames); err != nil {
+				return err
+			}
+			if err := w.WriteBool(cp != nil); err != nil {
+				return err
+			}
+			if cp != nil {
+				return writeFields(w, cp.fields, fieldNames)
+			}
+			return nil
+		}
+
+		unixNano, err := h.signature.unixNano()
+		if err != nil {
+			return err
+		}
+		var addedAuditors stringSlice
+		auditor := h.signature.auditor.Encode()
+		auditorIndex := auditors.IndexOf(auditor)
+		if auditorIndex < 0 {
+			auditorIndex = len(auditors)
+			auditors = append(auditors, auditor)
+			addedAuditors = append(addedAuditors, auditor)
+		}
+		err = writeRecord(w, recordTypeEntry, func(w *bufrw.Writer) error {
+			if err := w.WriteStrings(added...); err != nil {
+				return err
+			}
+			if err := w.WriteStrings(addedAuditors...); err != nil {
+				return err
+			}
+			if err := writeUvarint(w, uint64(auditorIndex)); err != nil {
+				return err
+			}
+			if err := writeVarint(w, unixNano-prevUnixNano); err != nil {
+				return err
+			}
+			if err := w.WriteBool(h.signature.metadata != nil); err != nil {
+				return err
+			}
+			if h.signature.metadata != nil {
+				if err := writeSignatureMetadata(w, h.signature.metadata); err != nil {
+					return err
+				}
+			}
+			if err := w.WriteByteValues(h.hash...); err != nil {
+				return err
+			}
+			if err := w.WriteByteValues(h.keySignature...); err != nil {
+				return err
+			}
+			return writeMaybeCompressed(w, values.valueCompression, writeValues)
+		})
+		if err != nil {
+			return err
+		}
+		prevUnixNano = unixNano
+	}
+
+	recordNameCount := len(fieldNames)
+	addFieldNames(values.latest)
+	var footerLength int
+	err := writeRecord(w, recordTypeFooter, func(w *bufrw.Writer) error {
+		if err := values.writeHeader(w); err != nil {
+			return err
+		}
+		var latestHash []byte
+		var latestSigned bool
+		if n := len(values.history); n > 0 {
+			latestHash = values.history[n-1].hash
+			latestSigned = values.history[n-1].keySignature != nil
+		}
+		if err := w.WriteByteValues(latestHash...); err != nil {
+			return err
+		}
+		if err := w.WriteBool(latestSigned); err != nil {
+			return err
+		}
+		if err := w.WriteStrings(fieldNames...); err != nil {
+			return err
+		}
+		if err := w.WriteInt(recordNameCount); err != nil {
+			return err
+		}
+		if err := w.WriteStrings(markerNames...); err != nil {
+			return err
+		}
+		if err := w.WriteStrings(auditors...); err != nil {
+			return err
+		}
+		if err := w.WriteBool(values.latest != nil); err != nil {
+			return err
+		}
+		if values.latest != nil {
+			if err := writeFields(w, values.latest, fieldNames); err != nil {
+				return err
+			}
+		}
+		if err := w.WriteInt(values.checkpointInterval); err != nil {
+			return err
+		}
+		return w.WriteBool(values.valueCompression)
+	}, &footerLength)
+	if err != nil {
+		return err
+	}
+	return w.WriteInt(footerLength)
+}
+
+// writeRecord writes the record type followed by the length prefixed body written
+// by write. If length is given, it is set to the length of the body.
+func writeRecord(w *bufrw.Writer, recordType byte, write func(w *bufrw.Writer) error, length ...*int) error {
+	var b bytes.Buffer
+	var buf bufrw.Buffer
+	if err := write(buf.Writer(&b)); err != nil {
+		return err
+	}
+	if len(length) > 0 {
+		*length[0] = b.Len()
+	}
+	if err := w.WriteByteValue(recordType); err != nil {
+		return err
+	}
+	return w.WriteByteValues(b.Bytes()...)
+}
+
+// flateWriters pools the flate writers used to compress values, which are costly
+// to allocate.
+var flateWriters = sync.Pool{
+	New: func() interface{} {
+		fw, _ := flate.NewWriter(nil, flate.BestCompression)
+		return fw
+	},
+}
+
+// writeMaybeCompressed writes whether the section written by write is compressed,
+// followed by the section. If compress is true, the section is compressed with
+// flate, unless that does not make it smaller.
+func writeMaybeCompressed(w *bufrw.Writer, compress bool, write func(w *bufrw.Writer) error) error {
+	if compress {
+		var raw, compressed bytes.Buffer
+		var buf bufrw.Buffer
+		if err := write(buf.Writer(&raw)); err != nil {
+			return err
+		}
+		fw := flateWriters.Get().(*flate.Writer)
+		defer flateWriters.Put(fw)
+		fw.Reset(&compressed)
+		if _, err := fw.Write(raw.Bytes()); err != nil {
+			return err
+		}
+		if err := fw.Close(); err != nil {
+			return err
+		}
+		// The length of the compressed bytes takes 4 bytes
+		if compressed.Len()+4 < raw.Len() {
+			if err := w.WriteBool(true); err != nil {
+				return err
+			}
+			return w.WriteByteValues(compressed.Bytes()...)
+		}
+	}
+	if err := w.WriteBool(false); err != nil {
+		return err
+	}
+	return write(w)
+}
+
+// readMaybeCompressed reads a section written by writeMaybeCompressed, passing a
+// reader of the section to read.
+func readMaybeCompressed(r *bufrw.Reader, read func(r *bufrw.Reader) error) error {
+	compressed, err := r.ReadBool()
+	if err != nil {
+		return err
+	}
+	if !compressed {
+		return read(r)
+	}
+	b, err := r.ReadByteValues()
+	if err != nil {
+		return err
+	}
+	fr := flate.NewReader(bytes.NewReader(b))
+	defer fr.Close()
+	var buf bufrw.Buffer
+	if err := read(buf.Reader(fr)); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return nil
+}
+
+func writeUvarint(w *bufrw.Writer, x uint64) error {
+	for _, b := range binary.AppendUvarint(nil, x) {
+		if err := w.WriteByteValue(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeVarint(w *bufrw.Writer, x int64) error {
+	for _, b := range binary.AppendVarint(nil, x) {
+		if err := w.WriteByteValue(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// byteReader reads single bytes from a bufrw.Reader, for reading varints.
+type byteReader struct {
+	r *bufrw.Reader
+}
+
+func (r byteReader) ReadByte() (byte, error) {
+	return r.r.ReadByteValue()
+}
+
+func readUvarint(r *bufrw.Reader) (uint64, error) {
+	return binary.ReadUvarint(byteReader{r})
+}
+
+func readVarint(r *bufrw.Reader) (int64, error) {
+	return binary.ReadVarint(byteReader{r})
+}
+
+// logFooter holds the contents of the footer record.
+type logFooter struct {
+	header          SerializedHeader
+	latestHash      []byte
+	latestSigned    bool
+	fieldNames      []string
+	recordNameCount int
+	markerNames     []string
+	auditors        []string
+	latest          fieldSlice
+	hasLatest       bool
+	interval        int
+	compression     bool
+}
+
+// readFooter reads the body of a footer record. The snapshot of the latest state
+// and the settings are only read if readAll is true.
+func readFooter(r *bufrw.Reader, readAll bool) (logFooter, error) {
+	var footer logFooter
+	var err error
+	if footer.header, err = readHeader(r); err != nil {
+		return logFooter{}, err
+	}
+	if footer.latestHash, err = r.ReadByteValues(); err != nil {
+		return logFooter{}, err
+	}
+	if footer.latestSigned, err = r.ReadBool(); err != nil {
+		return logFooter{}, err
+	}
+	if footer.fieldNames, err = readFieldNames(r); err != nil {
+		return logFooter{}, err
+	}
+	if footer.recordNameCount, err = r.ReadInt(); err != nil {
+		return logFooter{}, err
+	}
+	if footer.recordNameCount < 0 || footer.recordNameCount > len(footer.fieldNames) {
+		return logFooter{}, fmt.Errorf("invalid field name count: %d", footer.recordNameCount)
+	}
+	if footer.markerNames, err = readStringTable(r, "marker field names"); err != nil {
+		return logFooter{}, err
+	}
+	footer.header.FieldNames = make([]string, 0, len(footer.fieldNames))
+	for _, name := range footer.fieldNames {
+		if !stringSlice(footer.markerNames).Contains(name) {
+			footer.header.FieldNames = append(footer.header.FieldNames, name)
+		}
+	}
+	if footer.auditors, err = readStringTable(r, "auditors"); err != nil {
+		return logFooter{}, err
+	}
+	if !readAll {
+		return footer, nil
+	}
+	if footer.hasLatest, err = r.ReadBool(); err != nil {
+		return logFooter{}, err
+	}
+	if footer.hasLatest {
+		if footer.latest, err = readFields(r, footer.fieldNames); err != nil {
+			return logFooter{}, err
+		}
+	}
+	if footer.interval, err = r.ReadInt(); err != nil {
+		return logFooter{}, err
+	}
+	if footer.compression, err = r.ReadBool(); err != nil {
+		return logFooter{}, err
+	}
+	return footer, nil
+}
+
+// readFooterAt reads the footer of b, which holds values serialized in the log
+// layout, without reading the records. It also returns the offset of the footer
+// record in b.
+func readFooterAt(b []byte) (logFooter, int, error) {
+	var buf bufrw.Buffer
+	if len(b) < 4 {
+		return logFooter{}, 0, fmt.Errorf("invalid serialized values: missing footer")
+	}
+	footerLength, err := buf.Reader(bytes.NewReader(b[len(b)-4:])).ReadInt()
+	if err != nil {
+		return logFooter{}, 0, err
+	}
+	footerStart := len(b) - 4 - footerLength - 4 - 1
+	if footerLength < 0 || footerStart < 1 || b[footerStart] != recordTypeFooter {
+		return logFooter{}, 0, fmt.Errorf("invalid serialized values: missing footer")
+	}
+	r := buf.Reader(bytes.NewReader(b[footerStart+1+4 : len(b)-4]))
+	footer, err := readFooter(r, false)
+	if err != nil {
+		return logFooter{}, 0, err
+	}
+	return footer, footerStart, nil
+}
+
+// deserializeLogFrom deserializes values in the log layout, after the version
+// number has been read.
+func (values *AuditableValues) deserializeLogFrom(r *bufrw.Reader) error {
+	var history []auditHistory
+	var checkpoints []checkpoint
+	var fieldNames, auditors []string
+	var unixNano int64
+	for {
+		recordType, err := r.ReadByteValue()
+		if err != nil {
+			return err
+		}
+		if _, err := r.ReadInt(); err != nil {
+			return err
+		}
+		switch recordType {
+		case recordTypeEntry:
+			added, err := readFieldNames(r)
+			if err != nil {
+				return err
+			}
+			fieldNames = append(fieldNames, added...)
+			var h auditHistory
+			readValues := func(r *bufrw.Reader) error {
+				var err error
+				if h.fields, err = readFields(r, fieldNames); err != nil {
+					return err
+				}
+				hasCheckpoint, err := r.ReadBool()
+				if err != nil {
+					return err
+				}
+				if hasCheckpoint {
+					fields, err := readFields(r, fieldNames)
+					if err != nil {
+						return err
+					}
+					checkpoints = append(checkpoints, checkpoint{index: len(history), fields: fields})
+				}
+				return nil
+			}
+
+			addedAuditors, err := readStringTable(r, "auditors")
+			if err != nil {
+				return err
+			}
+			auditors = append(auditors, addedAuditors...)
+			auditorIndex, err := readUvarint(r)
+			if err != nil {
+				return err
+			}
+			if auditorIndex >= uint64(len(auditors)) {
+				return fmt.Errorf("invalid auditor index: %d", auditorIndex)
+			}
+			if err := h.signature.auditor.Decode(auditors[auditorIndex]); err != nil {
+				return err
+			}
+			delta, err := readVarint(r)
+			if err != nil {
+				return err
+			}
+			unixNano += delta
+			if h.signature.timestamp, err = timestampFromUnixNano(unixNano); err != nil {
+				return err
+			}
+			hasMetadata, err := r.ReadBool()
+			if err != nil {
+				return err
+			}
+			if hasMetadata {
+				if h.signature.metadata, err = readSignatureMetadata(r); err != nil {
+					return err
+				}
+			}
+			if h.hash, err = r.ReadByteValues(); err != nil {
+				return err
+			}
+			if h.keySignature, err = r.ReadByteValues(); err != nil {
+				return err
+			}
+			if len(h.keySignature) == 0 {
+				h.keySignature = nil
+			}
+			if err := readMaybeCompressed(r, readValues); err != nil {
+				return err
+			}
+			history = append(history, h)
+		case recordTypeKeySignature:
+			index, err := r.ReadInt()
+			if err != nil {
+				return err
+			}
+			if index < 0 || index >= len(history) || history[index].keySignature != nil {
+				return fmt.Errorf("invalid key signature record for history entry %d", index)
+			}
+			keySignature, err := r.ReadByteValues()
+			if err != nil {
+				return err
+			}
+			if len(keySignature) == 0 {
+				return fmt.Errorf("invalid key signature record for history entry %d", index)
+			}
+			history[index].keySignature = keySignature
+		case recordTypeFooter:
+			footer, err := readFooter(r, true)
+			if err != nil {
+				return err
+			}
+			latestSigned := len(history) > 0 && history[len(history)-1].keySignature != nil
+			if footer.header.EntryCount != len(history) || footer.recordNameCount != len(fieldNames) ||
+				len(footer.auditors) != len(auditors) || footer.latestSigned != latestSigned {
+				return fmt.Errorf("invalid serialized values: the footer does not match the records")
+			}
+			if _, err := r.ReadInt(); err != nil {
+				return err
+			}
+			if history == nil {
+				history = []auditHistory{}
+			}
+			values.history = history
+			values.checkpoints = checkpoints
+			values.checkpointInterval = footer.interval
+			values.valueCompression = footer.compression
+			values.latest = nil
+			if footer.hasLatest {
+				values.latest = footer.latest
+			}
+			return nil
+		default:
+			return fmt.Errorf("invalid record type: %d", recordType)
+		}
+	}
+}
+
+// readLogHeaderFrom reads the header of values in the log layout from the footer,
+// skipping the entry and key signature records without decoding them.
+func readLogHeaderFrom(r *bufrw.Reader) (SerializedHeader, error) {
+	for {
+		recordType, err := r.ReadByteValue()
+		if err != nil {
+			return SerializedHeader{}, err
+		}
+		length, err := r.ReadInt()
+		if err != nil {
+			return SerializedHeader{}, err
+		}
+		switch recordType {
+		case recordTypeEntry, recordTypeKeySignature:
+			if _, err := r.Read(length); err != nil {
+				return SerializedHeader{}, err
+			}
+		case recordTypeFooter:
+			footer, err := readFooter(r, false)
+			if err != nil {
+				return SerializedHeader{}, err
+			}
+			return footer.header, nil
+		default:
+			return SerializedHeader{}, fmt.Errorf("invalid record type: %d", recordType)
+		}
+	}
+}
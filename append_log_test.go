@@ -0,0 +1,316 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"fmt"
+	"github.com/snechholt/bufrw"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditableValuesAppendSerialized(t *testing.T) {
+	getSig := new(signatureGenerator).Next
+
+	obj := &auditableObject{Values: map[string]interface{}{"A": 0, "Unchanged": "x"}}
+	var av AuditableValues
+	av.SetCheckpointInterval(2)
+	b, err := av.AppendSerialized(nil)
+	if err != nil {
+		t.Fatalf("AppendSerialized() error on empty history: %v", err)
+	}
+	if _, err := av.Audit(nil, obj, getSig()); err != nil {
+		t.Fatal(err)
+	}
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i <= 5; i++ {
+		if i > 0 {
+			cpy := obj.Copy()
+			obj.Values = map[string]interface{}{"A": i, "Unchanged": "x"}
+			if i%2 == 0 {
+				obj.Values[fmt.Sprintf("B%d", i)] = i
+			}
+			if i == 5 {
+				obj.Values["Unchanged"] = "y"
+			}
+			if _, err := av.Audit(cpy, obj, getSig()); err != nil {
+				t.Fatal(err)
+			}
+			if err := av.SignLatest(key); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if b, err = av.AppendSerialized(b); err != nil {
+			t.Fatalf("AppendSerialized() error after update %d: %v", i, err)
+		}
+
+		// Appending writes the same bytes as serializing everything
+		want, err := av.Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, want) {
+			t.Fatalf("AppendSerialized() after update %d differs from Serialize()", i)
+		}
+		var got AuditableValues
+		if err := got.Deserialize(b); err != nil {
+			t.Fatalf("Deserialize() error after update %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(got, av) {
+			t.Errorf("Deserialize() after update %d does not return the appended values", i)
+		}
+	}
+
+	// Appending without changes only rewrites the footer
+	again, err := av.AppendSerialized(append([]byte(nil), b...))
+	if err != nil {
+		t.Fatalf("AppendSerialized() error without changes: %v", err)
+	}
+	if !bytes.Equal(again, b) {
+		t.Errorf("AppendSerialized() without changes modified the bytes")
+	}
+
+	// Appending to bytes of another history fails
+	var other AuditableValues
+	if _, err := other.Audit(nil, obj, getSig()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.AppendSerialized(append([]byte(nil), b...)); err == nil ||
+		!strings.Contains(err.Error(), "not a prefix") {
+		t.Errorf("AppendSerialized() of another history: want prefix error, got %v", err)
+	}
+
+	// Appending to a format without the log layout serializes everything
+	if got, err := av.AppendSerialized(serializeVersion(t, &av, 1)); err != nil {
+		t.Errorf("AppendSerialized() to version 1 error: %v", err)
+	} else if !bytes.Equal(got, b) {
+		t.Errorf("AppendSerialized() to version 1 differs from Serialize()")
+	}
+
+	// Appending after compaction fails, since the hash chain has changed
+	if err := av.Compact(obj, av.LatestSignature().Timestamp()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := av.AppendSerialized(append([]byte(nil), b...)); err == nil {
+		t.Errorf("AppendSerialized() after Compact(): want error, got nil")
+	}
+}
+
+func TestAuditableValuesSerializeCompressed(t *testing.T) {
+	getSig := new(signatureGenerator).Next
+
+	obj := &auditableObject{Values: map[string]interface{}{"A": 0, "Text": ""}}
+	var av AuditableValues
+	if _, err := av.Audit(nil, obj, getSig()); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 20; i++ {
+		cpy := obj.Copy()
+		obj.Values = map[string]interface{}{"A": i, "Text": strings.Repeat(fmt.Sprintf("line %d\n", i%3), 50)}
+		if _, err := av.Audit(cpy, obj, getSig()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	roundTrip := func(name string, b []byte) {
+		t.Helper()
+		var got AuditableValues
+		if err := got.Deserialize(b); err != nil {
+			t.Fatalf("Deserialize(%s) error: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, av) {
+			t.Errorf("Deserialize(%s) does not return the serialized values", name)
+		}
+	}
+
+	uncompressed, err := av.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip("uncompressed", uncompressed)
+
+	// Compressing the values makes repetitive values smaller
+	av.SetValueCompression(true)
+	compressed, err := av.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(compressed) >= len(uncompressed)/2 {
+		t.Errorf("Compressed values are not smaller: %d bytes, uncompressed %d bytes", len(compressed), len(uncompressed))
+	}
+	roundTrip("compressed", compressed)
+	header, err := ReadSerializedHeader(compressed)
+	if err != nil {
+		t.Fatalf("ReadSerializedHeader() error: %v", err)
+	}
+	if want := av.header(); !reflect.DeepEqual(header, want) {
+		t.Errorf("Wrong header of compressed values\nWant %v\nGot  %v", want, header)
+	}
+
+	// Appending to the uncompressed bytes writes the new entries compressed
+	cpy := obj.Copy()
+	obj.Values = map[string]interface{}{"A": -1, "Text": strings.Repeat("line\n", 50), "New": true}
+	if _, err := av.Audit(cpy, obj, getSig()); err != nil {
+		t.Fatal(err)
+	}
+	b, err := av.AppendSerialized(uncompressed)
+	if err != nil {
+		t.Fatalf("AppendSerialized() error: %v", err)
+	}
+	roundTrip("appended", b)
+}
+
+func TestAuditableValuesAppendSerializedSignatureMetadata(t *testing.T) {
+	support, customer := NewAuditor("support", "7"), NewAuditor("user", "1")
+	t1 := time.Date(2010, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// Appending to version 1, which has no hash chain, serializes the values in the
+	// current version, which must keep the metadata of the signatures
+	for _, sig := range []Signature{
+		NewSignature(customer, t1.Add(time.Hour), WithReason("Fix typo"), WithAttribute("ticket", "ABC-1")),
+		NewSignature(support, t1.Add(time.Hour), WithOnBehalfOf(customer)),
+	} {
+		obj := &auditableObject{Values: map[string]interface{}{"A": 1}}
+		var av AuditableValues
+		if _, err := av.Audit(nil, obj, NewSignature(customer, t1)); err != nil {
+			t.Fatal(err)
+		}
+		prev := serializeVersion(t, &av, 1)
+		cpy := obj.Copy()
+		obj.Values = map[string]interface{}{"A": 2}
+		if _, err := av.Audit(cpy, obj, sig); err != nil {
+			t.Fatal(err)
+		}
+		b, err := av.AppendSerialized(prev)
+		if err != nil {
+			t.Fatalf("AppendSerialized() error with %s: %v", sig, err)
+		}
+		if want, err := av.Serialize(); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(b, want) {
+			t.Errorf("AppendSerialized() with %s differs from Serialize()", sig)
+		}
+		var got AuditableValues
+		if err := got.Deserialize(b); err != nil {
+			t.Fatalf("Deserialize() error with %s: %v", sig, err)
+		}
+		if latest := got.LatestSignature(); !latest.Equal(sig) {
+			t.Errorf("Wrong latest signature: %s, want %s", latest, sig)
+		}
+		if err := got.Verify(); err != nil {
+			t.Errorf("Verify() error with %s: %v", sig, err)
+		}
+	}
+}
+
+func TestAuditableValuesAppendSerializedKeySignature(t *testing.T) {
+	getSig := new(signatureGenerator).Next
+	pub, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey := func(Auditor) (ed25519.PublicKey, error) { return pub, nil }
+
+	obj := &auditableObject{Values: map[string]interface{}{"A": 1}}
+	var av AuditableValues
+	if _, err := av.Audit(nil, obj, getSig()); err != nil {
+		t.Fatal(err)
+	}
+	b, err := av.AppendSerialized(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Signing the latest entry after it was written appends the signature, both
+	// without and with new entries
+	for i := 0; i < 2; i++ {
+		if i > 0 {
+			cpy := obj.Copy()
+			obj.Values = map[string]interface{}{"A": 2}
+			if _, err := av.Audit(cpy, obj, getSig()); err != nil {
+				t.Fatal(err)
+			}
+			if b, err = av.AppendSerialized(b); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := av.SignLatest(key); err != nil {
+			t.Fatal(err)
+		}
+		if b, err = av.AppendSerialized(b); err != nil {
+			t.Fatalf("AppendSerialized() error after SignLatest(): %v", err)
+		}
+		var got AuditableValues
+		if err := got.Deserialize(b); err != nil {
+			t.Fatalf("Deserialize() error after SignLatest(): %v", err)
+		}
+		if !reflect.DeepEqual(got, av) {
+			t.Errorf("Deserialize() after SignLatest() does not return the signed values")
+		}
+		if unverified, err := got.VerifySignatures(publicKey); err != nil || len(unverified) != 0 {
+			t.Errorf("VerifySignatures() after SignLatest() = %v, %v, want no unverified entries", unverified, err)
+		}
+	}
+
+	// Appending without changes does not write the signature again
+	again, err := av.AppendSerialized(append([]byte(nil), b...))
+	if err != nil {
+		t.Fatalf("AppendSerialized() error without changes: %v", err)
+	}
+	if !bytes.Equal(again, b) {
+		t.Errorf("AppendSerialized() without changes modified the bytes")
+	}
+
+	// The key signature records are skipped when reading the header
+	var buf bufrw.Buffer
+	header, err := ReadSerializedHeaderFrom(buf.Reader(bytes.NewReader(b)))
+	if err != nil {
+		t.Fatalf("ReadSerializedHeaderFrom() error: %v", err)
+	}
+	if want := av.header(); !reflect.DeepEqual(header, want) {
+		t.Errorf("Wrong header\nWant %v\nGot  %v", want, header)
+	}
+}
+
+func TestAuditableValuesAppendSerializedError(t *testing.T) {
+	getSig := new(signatureGenerator).Next
+
+	obj := &auditableObject{Values: map[string]interface{}{"A": 0}}
+	var av AuditableValues
+	if _, err := av.Audit(nil, obj, getSig()); err != nil {
+		t.Fatal(err)
+	}
+	b, err := av.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append([]byte(nil), b...)
+
+	// The second new entry has a timestamp that cannot be serialized, which is only
+	// found after the first entry is encoded
+	for i, sig := range []Signature{getSig(), getSig()} {
+		if i == 1 {
+			sig.timestamp = sig.timestamp.In(time.FixedZone("UTC+1", 3600))
+		}
+		cpy := obj.Copy()
+		obj.Values = map[string]interface{}{"A": i + 1}
+		if _, err := av.Audit(cpy, obj, sig); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := av.AppendSerialized(b); err == nil {
+		t.Fatalf("AppendSerialized() with a non-UTC timestamp: want error, got nil")
+	}
+	if !bytes.Equal(b, want) {
+		t.Errorf("AppendSerialized() modified prev when returning an error")
+	}
+	var got AuditableValues
+	if err := got.Deserialize(b); err != nil {
+		t.Errorf("Deserialize() error after failed AppendSerialized(): %v", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/snechholt/bufrw"
+	"sort"
 	"strings"
 	"time"
 )
@@ -14,8 +15,9 @@ var ErrDidNotExist = fmt.Errorf("the object did not exist at the given time")
 type magicValue int8
 
 const (
-	magicValueHistoryCreation magicValue = 0
-	magicValueFieldRemoved    magicValue = 1
+	magicValueHistoryCreation  magicValue = 0
+	magicValueFieldRemoved     magicValue = 1
+	magicValueHistoryTruncated magicValue = 2
 )
 
 func (v magicValue) String() string {
@@ -24,6 +26,8 @@ func (v magicValue) String() string {
 		return "<created>"
 	case magicValueFieldRemoved:
 		return "<field removed>"
+	case magicValueHistoryTruncated:
+		return "<truncated>"
 	default:
 		return fmt.Sprintf("<invalid magic value (%d)>", v)
 	}
@@ -32,17 +36,48 @@ func (v magicValue) String() string {
 type auditHistory struct {
 	fields    fieldSlice
 	signature Signature
+
+	// hash links the entry to the entry before it. See Verify.
+	hash []byte
+	// keySignature is the ed25519 signature of hash, or nil if the entry is not
+	// signed. See SignLatest.
+	keySignature []byte
 }
 
 type AuditableValues struct {
 	history []auditHistory
+
+	// latest is a snapshot of the flattened fields as of the latest audit, which
+	// allows rolled back objects to be rolled forward again. It is nil for
+	// histories deserialized from a format that did not include it, until the
+	// next audit.
+	latest fieldSlice
+
+	// checkpoints are snapshots of the flattened fields stored every
+	// checkpointInterval entries, ordered by index. See SetCheckpointInterval.
+	checkpoints        []checkpoint
+	checkpointInterval int
+
+	// valueCompression is whether values are compressed when serialized. See
+	// SetValueCompression.
+	valueCompression bool
 }
 
-func (values *AuditableValues) addHistory(sig Signature, fields ...Field) {
-	values.history = append(values.history, auditHistory{
+func (values *AuditableValues) addHistory(sig Signature, fields ...Field) error {
+	h := auditHistory{
 		fields:    fields,
 		signature: sig,
-	})
+	}
+	var prev []byte
+	if n := len(values.history); n > 0 {
+		prev = values.history[n-1].hash
+	}
+	var err error
+	if h.hash, err = h.chainHash(prev); err != nil {
+		return err
+	}
+	values.history = append(values.history, h)
+	return nil
 }
 
 // IsZero returns whether or not the values object represents the zero
@@ -52,7 +87,8 @@ func (values *AuditableValues) IsZero() bool {
 }
 
 // CreationSignature returns the first signature of the audit history, the
-// entry that signifies the creation of the history.
+// entry that signifies the creation of the history. For compacted histories,
+// this is the signature of the baseline (see Compact).
 func (values *AuditableValues) CreationSignature() Signature {
 	if values.IsZero() {
 		return Signature{}
@@ -82,11 +118,13 @@ func (values *AuditableValues) Signatures() SignatureSlice {
 
 // LatestSignatureForField returns the signature of the last update that changed
 // the specified field value. If the field has not been changed since the creation
-// of the audit history, the creation signature is returned.
+// of the audit history, the creation signature is returned. The field name may be
+// the path of a nested object, in which case changes to any of its nested fields
+// are included.
 func (values *AuditableValues) LatestSignatureForField(fieldName string) Signature {
 	for i := len(values.history) - 1; i > 0; i-- {
 		for _, field := range values.history[i].fields {
-			if field.Name == fieldName {
+			if fieldPathHasPrefix(field.Name, fieldName) {
 				return values.history[i].signature
 			}
 		}
@@ -95,12 +133,13 @@ func (values *AuditableValues) LatestSignatureForField(fieldName string) Signatu
 }
 
 // SignaturesForField returns the signatures of all updates that affected the
-// specified field.
+// specified field. As with LatestSignatureForField, the field name may be the
+// path of a nested object.
 func (values *AuditableValues) SignaturesForField(fieldName string) SignatureSlice {
 	signatures := SignatureSlice{values.CreationSignature()}
 	for _, h := range values.history {
 		for _, field := range h.fields {
-			if field.Name == fieldName {
+			if fieldPathHasPrefix(field.Name, fieldName) {
 				signatures = append(signatures, h.signature)
 				break
 			}
@@ -134,6 +173,8 @@ func (field Field) String() string {
 	return fmt.Sprintf("{ %s: %T %v }", field.Name, field.Value, field.Value)
 }
 
+// AuditableObject describes an object whose fields can be audited and rolled back.
+// Fields with a []Field value are treated as nested objects, see FieldPathSeparator.
 type AuditableObject interface {
 	GetFields() (fields []Field, tRollback time.Time, err error)
 	SetFields(fields []Field, tRollback time.Time) error
@@ -160,28 +201,43 @@ func (values *AuditableValues) Audit(oldObj, newObj AuditableObject, sig Signatu
 		if !tRollback.IsZero() {
 			return false, fmt.Errorf("cannot audit based on a rolled back object")
 		}
-		oldFields = fields
+		oldFields = flattenFields(fields)
 	}
-	newFields, tRollback, err := newObj.GetFields()
+	_newFields, tRollback, err := newObj.GetFields()
 	if err != nil {
 		return false, err
 	}
 	if !tRollback.IsZero() {
 		return false, fmt.Errorf("cannot audit based on a rolled back object")
 	}
+	newFields := flattenFields(_newFields)
 	changedFields, err := values.getHistoryFields(oldFields, newFields)
 	if err != nil {
 		return false, err
 	}
 	n := len(changedFields)
 	if n == 0 {
+		if values.latest == nil {
+			values.latest = newFields
+		}
 		return false, nil
 	}
-	values.addHistory(sig, changedFields...)
+	if err := values.addHistory(sig, changedFields...); err != nil {
+		return false, err
+	}
+	values.addCheckpoint(newFields)
+	values.latest = newFields
 	return true, nil
 }
 
 func (_ *AuditableValues) getHistoryFields(oldFields, newFields fieldSlice) (fieldSlice, error) {
+	for _, fields := range []fieldSlice{oldFields, newFields} {
+		for _, field := range fields {
+			if err := checkValueType(field.Value); err != nil {
+				return nil, fmt.Errorf("cannot audit field %s: %w", field.Name, err)
+			}
+		}
+	}
 	if oldFields == nil {
 		return fieldSlice{{"", magicValueHistoryCreation}}, nil
 	}
@@ -211,39 +267,91 @@ func (values *AuditableValues) RollbackTo(obj AuditableObject, t time.Time) erro
 		return fmt.Errorf("invalid state: empty history")
 	}
 	if tCreation := values.history[0].signature.timestamp; t.Before(tCreation) {
-		return ErrDidNotExist
+		return values.errBeforeHistory()
 	}
-	_currentFields, tRollback, err := obj.GetFields()
+	currentFields, tRollback, err := getFlattenedFields(obj)
 	if err != nil {
 		return fmt.Errorf("error rolling back object: %w", err)
 	}
-	currentFields := fieldSlice(_currentFields)
-	isRolledBack := !tRollback.IsZero()
-	if isRolledBack && tRollback.Before(t) {
-		return fmt.Errorf("object is already rolled back to a timestamp earlier than t (tRollback: %s, t: %s)",
-			tRollback, t)
+	target := values.indexAt(t)
+	start, currentFields, err := values.rollbackStart(target, currentFields, tRollback, t)
+	if err != nil {
+		return err
 	}
+	currentFields = currentFields.copy()
 	// Go through the history in descending order and apply (or rather, "undo") the changes
-	// Note that we don't include values.history[0], since this is the creation entry
-	for i := len(values.history) - 1; i > 0; i-- {
-		history := values.history[i]
-		tHistory := history.signature.timestamp
-		if isRolledBack && tHistory.After(tRollback) {
-			continue
-		}
-		if !tHistory.After(t) {
-			continue
-		}
-		for _, field := range history.fields {
-			switch field.Value {
-			case magicValueFieldRemoved:
-				currentFields.Remove(field.Name)
-			default:
-				currentFields.Set(field.Name, field.Value)
-			}
+	// Note that we never include values.history[0], since this is the creation entry
+	for i := start; i > target; i-- {
+		values.history[i].undo(&currentFields)
+	}
+	return obj.SetFields(unflattenFields(currentFields), t)
+}
+
+// Restore sets the fields of obj to the state as of the latest audit, undoing any
+// rollback of the object. It requires the snapshot of the latest state, which is
+// recorded by Audit and not available for histories deserialized from versions
+// of the format that did not include it.
+func (values *AuditableValues) Restore(obj AuditableObject) error {
+	if values.latest == nil {
+		return fmt.Errorf("cannot restore object: the history has no snapshot of the latest state")
+	}
+	return obj.SetFields(unflattenFields(values.latest.copy()), time.Time{})
+}
+
+// rollbackStart returns the nearest state after the entry at index target that
+// changes can be undone from to get the state as of target, along with the index
+// of the entry that brought the object to that state. That is either current, the
+// state of an object rolled back to tRollback, unless it is rolled back to before
+// t, or a snapshot (the latest state or a checkpoint). The returned fields must
+// not be modified.
+func (values *AuditableValues) rollbackStart(target int, current fieldSlice, tRollback, t time.Time) (int, fieldSlice, error) {
+	start := -1
+	if tRollback.IsZero() {
+		start = len(values.history) - 1
+	} else if !tRollback.Before(t) {
+		start = values.indexAt(tRollback)
+	}
+	if snapshotIndex, snapshot := values.nearestSnapshot(target); snapshot != nil && (start == -1 || snapshotIndex < start) {
+		start, current = snapshotIndex, snapshot
+	}
+	if start == -1 {
+		// Rolling forward is only possible from a snapshot, since the history only
+		// stores the values from before each audit
+		return 0, nil, fmt.Errorf("object is already rolled back to a timestamp earlier than t (tRollback: %s, t: %s)",
+			tRollback, t)
+	}
+	return start, current, nil
+}
+
+// indexAt returns the index of the latest history entry with a timestamp not after
+// t, or -1 if t is before the creation of the history.
+func (values *AuditableValues) indexAt(t time.Time) int {
+	return sort.Search(len(values.history), func(i int) bool {
+		return values.history[i].signature.timestamp.After(t)
+	}) - 1
+}
+
+// getFlattenedFields returns the fields of obj with nested fields flattened into
+// field paths.
+func getFlattenedFields(obj AuditableObject) (fieldSlice, time.Time, error) {
+	fields, tRollback, err := obj.GetFields()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return flattenFields(fields), tRollback, nil
+}
+
+// undo reverts the changes of the history entry, turning fields from the state
+// after the entry into the state before it.
+func (h auditHistory) undo(fields *fieldSlice) {
+	for _, field := range h.fields {
+		switch field.Value {
+		case magicValueFieldRemoved:
+			fields.Remove(field.Name)
+		default:
+			fields.Set(field.Name, field.Value)
 		}
 	}
-	return obj.SetFields(currentFields, t)
 }
 
 func (values *AuditableValues) Serialize() ([]byte, error) {
@@ -255,9 +363,31 @@ func (values *AuditableValues) Serialize() ([]byte, error) {
 	return b.Bytes(), nil
 }
 
+// serializationVersion is the version of the format written by SerializeTo.
+// DeserializeFrom reads all versions up to and including this version.
+//
+// Version history:
+//
+//	1: Field names and history entries
+//	2: A log of records that can be appended to, holding the history entries with
+//	   their hashes and ed25519 signatures, the snapshot of the latest state, the
+//	   checkpoints and the header. See AppendSerialized
+const serializationVersion = 2
+
 func (values *AuditableValues) SerializeTo(w *bufrw.Writer) error {
+	return values.serializeVersionTo(w, serializationVersion)
+}
+
+// serializeVersionTo serializes the values in the given format version, leaving out
+// whatever the version does not support. It allows testing deserialization of
+// older versions.
+func (values *AuditableValues) serializeVersionTo(w *bufrw.Writer, version byte) error {
+	if version >= logVersion {
+		return values.serializeLogTo(w)
+	}
+
 	// Write version number
-	if err := w.WriteByteValue(1); err != nil {
+	if err := w.WriteByteValue(version); err != nil {
 		return err
 	}
 
@@ -274,8 +404,9 @@ func (values *AuditableValues) SerializeTo(w *bufrw.Writer) error {
 	if err := w.WriteInt(len(fieldNames)); err != nil {
 		return err
 	}
+	// Field paths did not exist in version 1, so the names are written unescaped
 	for _, key := range fieldNames {
-		if err := w.WriteString(key); err != nil {
+		if err := w.WriteString(strings.Join(splitFieldPath(key), FieldPathSeparator)); err != nil {
 			return err
 		}
 	}
@@ -288,73 +419,9 @@ func (values *AuditableValues) SerializeTo(w *bufrw.Writer) error {
 		if err := w.WriteSerializable(&obj.signature); err != nil {
 			return err
 		}
-
-		nFields := len(obj.fields)
-		if err := w.WriteInt(nFields); err != nil {
+		if err := writeFields(w, obj.fields, fieldNames); err != nil {
 			return err
 		}
-		for _, field := range obj.fields {
-			nameIndex := fieldNames.IndexOf(field.Name)
-			if err := w.WriteInt(nameIndex); err != nil {
-				return err
-			}
-			var err error
-			switch v := field.Value.(type) {
-			case magicValue:
-				if err = w.WriteByteValue(0); err == nil {
-					err = w.WriteByteValue(byte(v))
-				}
-			case string:
-				if err = w.WriteByteValue(1); err == nil {
-					err = w.WriteString(v)
-				}
-			case bool:
-				if err := w.WriteByteValue(2); err == nil {
-					err = w.WriteBool(v)
-				}
-			case int:
-				if err = w.WriteByteValue(3); err == nil {
-					err = w.WriteInt(v)
-				}
-			case int64:
-				if err = w.WriteByteValue(4); err == nil {
-					err = w.WriteInt64(v)
-				}
-			case float64:
-				if err = w.WriteByteValue(5); err == nil {
-					err = w.WriteFloat64(v)
-				}
-			case []string:
-				if err := w.WriteByteValue(6); err == nil {
-					err = w.WriteStrings(v...)
-				}
-			case []bool:
-				if err := w.WriteByteValue(7); err == nil {
-					err = w.WriteBools(v...)
-				}
-			case []int:
-				if err := w.WriteByteValue(8); err == nil {
-					err = w.WriteInts(v...)
-				}
-			case []int64:
-				if err := w.WriteByteValue(9); err == nil {
-					err = w.WriteInt64s(v...)
-				}
-			case []float64:
-				if err := w.WriteByteValue(10); err == nil {
-					err = w.WriteFloat64s(v...)
-				}
-			case []byte:
-				if err := w.WriteByteValue(11); err == nil {
-					err = w.WriteByteValues(v...)
-				}
-			default:
-				err = fmt.Errorf("cannot serialize value of type %T", field.Value)
-			}
-			if err != nil {
-				return err
-			}
-		}
 	}
 	return nil
 }
@@ -369,21 +436,27 @@ func (values *AuditableValues) DeserializeFrom(r *bufrw.Reader) error {
 	if err != nil {
 		return err
 	}
-	if version != 1 {
+	if version < 1 || version > serializationVersion {
 		return fmt.Errorf("invalid version number: %d", version)
 	}
+	return values.deserializeVersionFrom(r, version)
+}
+
+// deserializeVersionFrom deserializes values of the given format version, after
+// the version number has been read.
+func (values *AuditableValues) deserializeVersionFrom(r *bufrw.Reader, version byte) error {
+	if version >= logVersion {
+		return values.deserializeLogFrom(r)
+	}
 
-	nFieldNames, err := r.ReadInt()
+	fieldNames, err := readFieldNames(r)
 	if err != nil {
 		return err
 	}
-	fieldNames := make([]string, nFieldNames)
-	for i := 0; i < nFieldNames; i++ {
-		key, err := r.ReadString()
-		if err != nil {
-			return err
-		}
-		fieldNames[i] = key
+	// Escape the names, which may contain the path separator, since fields could not
+	// be nested in version 1
+	for i, name := range fieldNames {
+		fieldNames[i] = FieldPath(name)
 	}
 
 	nHistory, err := r.ReadInt()
@@ -396,63 +469,62 @@ func (values *AuditableValues) DeserializeFrom(r *bufrw.Reader) error {
 		if err := r.ReadSerializable(&sig); err != nil {
 			return err
 		}
-		nFields, err := r.ReadInt()
+		fields, err := readFields(r, fieldNames)
 		if err != nil {
 			return err
 		}
-		fields := make(fieldSlice, nFields)
-		for i := 0; i < nFields; i++ {
-			nameIndex, err := r.ReadInt()
-			if err != nil {
-				return err
-			}
-			name := fieldNames[nameIndex]
-
-			var value interface{}
-			valueType, err := r.ReadByteValue()
-			switch valueType {
-			case 0:
-				v, err := r.ReadByteValue()
-				if err != nil {
-					return err
-				}
-				value = magicValue(v)
-			case 1:
-				value, err = r.ReadString()
-			case 2:
-				value, err = r.ReadBool()
-			case 3:
-				value, err = r.ReadInt()
-			case 4:
-				value, err = r.ReadInt64()
-			case 5:
-				value, err = r.ReadFloat64()
-			case 6:
-				value, err = r.ReadStrings()
-			case 7:
-				value, err = r.ReadBools()
-			case 8:
-				value, err = r.ReadInts()
-			case 9:
-				value, err = r.ReadInt64s()
-			case 10:
-				value, err = r.ReadFloat64s()
-			case 11:
-				value, err = r.ReadByteValues()
-			default:
-				err = fmt.Errorf("invalid value type: %d", valueType)
-			}
-			if err != nil {
-				return err
-			}
-			fields[i] = Field{name, value}
-		}
 		values.history[i].signature = sig
 		values.history[i].fields = fields
 	}
+	values.latest = nil
+	values.checkpointInterval = 0
+	values.checkpoints = nil
+	values.valueCompression = false
+	return values.computeHashes(0)
+}
+
+// writeFields writes the number of fields followed by the index of the name and
+// the value of each field.
+func writeFields(w *bufrw.Writer, fields fieldSlice, fieldNames stringSlice) error {
+	if err := w.WriteInt(len(fields)); err != nil {
+		return err
+	}
+	for _, field := range fields {
+		nameIndex := fieldNames.IndexOf(field.Name)
+		if err := w.WriteInt(nameIndex); err != nil {
+			return err
+		}
+		if err := writeValue(w, field.Value); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// readFields reads fields written by writeFields.
+func readFields(r *bufrw.Reader, fieldNames []string) (fieldSlice, error) {
+	nFields, err := r.ReadInt()
+	if err != nil {
+		return nil, err
+	}
+	fields := make(fieldSlice, nFields)
+	for i := 0; i < nFields; i++ {
+		nameIndex, err := r.ReadInt()
+		if err != nil {
+			return nil, err
+		}
+		if nameIndex < 0 || nameIndex >= len(fieldNames) {
+			return nil, fmt.Errorf("invalid field name index: %d", nameIndex)
+		}
+		value, err := readValue(r)
+		if err != nil {
+			return nil, err
+		}
+		fields[i] = Field{fieldNames[nameIndex], value}
+	}
+	return fields, nil
+}
+
 type fieldSlice []Field
 
 func (s fieldSlice) IndexOf(name string) int {
@@ -464,6 +536,15 @@ func (s fieldSlice) IndexOf(name string) int {
 	return -1
 }
 
+func (s fieldSlice) copy() fieldSlice {
+	if s == nil {
+		return nil
+	}
+	cpy := make(fieldSlice, len(s))
+	copy(cpy, s)
+	return cpy
+}
+
 func (s fieldSlice) Contains(name string) bool {
 	return s.IndexOf(name) != -1
 }
@@ -1,7 +1,9 @@
 package audit
 
 import (
+	"bytes"
 	"fmt"
+	"github.com/snechholt/bufrw"
 	"reflect"
 	"testing"
 	"time"
@@ -52,6 +54,7 @@ func TestAuditableValuesGetHistoryFields(t *testing.T) {
 			{"int64", int64(1)},
 			{"float64", float64(1)},
 			{"bool", true},
+			{"time.Time", time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC)},
 		}
 		newFields := []Field{
 			{"string", "2"},
@@ -59,6 +62,7 @@ func TestAuditableValuesGetHistoryFields(t *testing.T) {
 			{"int64", int64(2)},
 			{"float64", float64(2)},
 			{"bool", false},
+			{"time.Time", time.Date(2010, 1, 1, 0, 0, 0, 1, time.UTC)},
 		}
 
 		for _, newField := range newFields {
@@ -94,6 +98,7 @@ func TestAuditableValuesGetHistoryFields(t *testing.T) {
 			{"[]int64", []int64{1}},
 			{"[]float64", []float64{1}},
 			{"[]bool", []bool{true}},
+			{"[]time.Time", []time.Time{time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC)}},
 		}
 		newFields := []Field{
 			{"[]string", []string{"2"}},
@@ -101,6 +106,7 @@ func TestAuditableValuesGetHistoryFields(t *testing.T) {
 			{"[]int64", []int64{2}},
 			{"[]float64", []float64{2}},
 			{"[]bool", []bool{false}},
+			{"[]time.Time", []time.Time{time.Date(2010, 1, 2, 0, 0, 0, 0, time.UTC)}},
 		}
 
 		for _, newField := range newFields {
@@ -127,6 +133,21 @@ func TestAuditableValuesGetHistoryFields(t *testing.T) {
 		}
 	}
 
+	// Times are compared by the instant they represent, not by their location
+	{
+		t1 := time.Date(2010, 1, 1, 12, 0, 0, 0, time.UTC)
+		t2 := t1.In(time.FixedZone("UTC+1", 3600))
+		oldFields := []Field{{"time.Time", t1}, {"[]time.Time", []time.Time{t1}}}
+		newFields := []Field{{"time.Time", t2}, {"[]time.Time", []time.Time{t2}}}
+		got, err := v.getHistoryFields(oldFields, newFields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) > 0 {
+			t.Errorf("Wrong fields returned when times are equal in different locations\nWant nil\nGot  %v", got)
+		}
+	}
+
 	// Return magic key "removed" when key is present in new fields and not in old fields
 	{
 		oldFields := []Field{}
@@ -309,10 +330,13 @@ func TestAuditableValuesAuditAndRollbackBasicTypes(t *testing.T) {
 		}
 
 		// Validate that we can't roll back to a time *after* the current rollback time
+		// when the history has no snapshot of the latest state to roll forward from
+		avWithoutSnapshot := av
+		avWithoutSnapshot.latest = nil
 		tAfterRollback := test.tRollback.Add(time.Second)
 		wantErr := fmt.Errorf("object is already rolled back to a timestamp earlier than t (tRollback: %s, t: %s)",
 			test.tRollback, tAfterRollback)
-		if err := av.RollbackTo(obj, tAfterRollback); !gotError(err, wantErr) {
+		if err := avWithoutSnapshot.RollbackTo(obj, tAfterRollback); !gotError(err, wantErr) {
 			t.Fatalf("Wrong error returned when rolling back with t > tRollback (%s)\nWant %v\nGot  %v", test.name, wantErr, err)
 		}
 		if !reflect.DeepEqual(want, obj) {
@@ -564,10 +588,13 @@ func TestAuditableValuesAuditAndRollbackSliceTypes(t *testing.T) {
 		}
 
 		// Validate that we can't roll back to a time *after* the current rollback time
+		// when the history has no snapshot of the latest state to roll forward from
+		avWithoutSnapshot := av
+		avWithoutSnapshot.latest = nil
 		tAfterRollback := test.tRollback.Add(time.Second)
 		wantErr := fmt.Errorf("object is already rolled back to a timestamp earlier than t (tRollback: %s, t: %s)",
 			test.tRollback, tAfterRollback)
-		if err := av.RollbackTo(obj, tAfterRollback); !gotError(err, wantErr) {
+		if err := avWithoutSnapshot.RollbackTo(obj, tAfterRollback); !gotError(err, wantErr) {
 			t.Fatalf("Wrong error returned when rolling back with t > tRollback (%s)\nWant %v\nGot  %v", test.name, wantErr, err)
 		}
 		if !reflect.DeepEqual(want, obj) {
@@ -787,19 +814,13 @@ func (obj *auditableObject) String() string {
 
 func TestAuditableValuesSerialization(t *testing.T) {
 	getSig := new(signatureGenerator).Next
-	getHistory := func(values ...interface{}) struct {
-		fields    fieldSlice
-		signature Signature
-	} {
+	getHistory := func(values ...interface{}) auditHistory {
 		fields := make([]Field, 0, len(values))
 		for i, value := range values {
 			name := fmt.Sprintf("field%d", i)
 			fields = append(fields, Field{name, value})
 		}
-		return struct {
-			fields    fieldSlice
-			signature Signature
-		}{fields, getSig()}
+		return auditHistory{fields: fields, signature: getSig()}
 	}
 	value := AuditableValues{
 		history: []auditHistory{
@@ -818,8 +839,21 @@ func TestAuditableValuesSerialization(t *testing.T) {
 			getHistory([]int{-1, 0, 1}, []int{1, 2, 3}),                // []int
 			getHistory([]int64{-1, 0, 1}, []int64{1, 2, 3}),            // int64
 			getHistory([]float64{-1.5, 0, 1.5}),                        // []float64
+			// Fixed-size numeric types
+			getHistory(int8(-128), int8(127), int16(-32768), int16(32767), int32(-1<<31), int32(1<<31-1)),
+			getHistory(uint(0), uint(1<<64-1), uint8(255), uint16(65535), uint32(1<<32-1), uint64(1<<64-1)),
+			getHistory(float32(-1.5), float32(3.4e38)),
+			getHistory([]int8{-1, 1}, []int16{-1, 1}, []int32{-1, 1}),
+			getHistory([]uint{0, 1<<64 - 1}, []uint16{0, 65535}, []uint32{0, 1<<32 - 1}, []uint64{0, 1<<64 - 1}),
+			getHistory([]float32{-1.5, 1.5}),
+			// Times
+			getHistory(time.Time{}, time.Date(2010, 1, 2, 3, 4, 5, 123456789, time.UTC)),        // time.Time
+			getHistory([]time.Time{time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)}, []time.Time{}), // []time.Time
 		},
 	}
+	if err := value.computeHashes(0); err != nil {
+		t.Fatal(err)
+	}
 	b, err := value.Serialize()
 	if err != nil {
 		t.Fatalf("Serialize() error: %v", err)
@@ -833,6 +867,214 @@ func TestAuditableValuesSerialization(t *testing.T) {
 	}
 }
 
+// Tests that values of all numeric types are audited and rolled back with their exact type
+func TestAuditableValuesAuditAndRollbackNumericTypes(t *testing.T) {
+	getSig := new(signatureGenerator).Next
+
+	obj := &auditableObject{
+		Values: map[string]interface{}{
+			"int8":      int8(1),
+			"int16":     int16(1),
+			"int32":     int32(1),
+			"uint":      uint(1),
+			"uint8":     uint8(1),
+			"uint16":    uint16(1),
+			"uint32":    uint32(1),
+			"uint64":    uint64(1),
+			"float32":   float32(1),
+			"[]int8":    []int8{1},
+			"[]int16":   []int16{1},
+			"[]int32":   []int32{1},
+			"[]uint":    []uint{1},
+			"[]uint16":  []uint16{1},
+			"[]uint32":  []uint32{1},
+			"[]uint64":  []uint64{1},
+			"[]float32": []float32{1},
+		},
+	}
+
+	var av AuditableValues
+	if _, err := av.Audit(nil, obj, getSig()); err != nil {
+		t.Fatal(err)
+	}
+	stateAtCreation := obj.Copy()
+
+	updateSignature := getSig()
+	{
+		cpy := obj.Copy()
+		obj.Values = map[string]interface{}{
+			"int8":      int8(2),
+			"int16":     int16(2),
+			"int32":     int32(2),
+			"uint":      uint(2),
+			"uint8":     uint8(2),
+			"uint16":    uint16(2),
+			"uint32":    uint32(2),
+			"uint64":    uint64(2),
+			"float32":   float32(2),
+			"[]int8":    []int8{1, 2},
+			"[]int16":   []int16{1, 2},
+			"[]int32":   []int32{1, 2},
+			"[]uint":    []uint{1, 2},
+			"[]uint16":  []uint16{1, 2},
+			"[]uint32":  []uint32{1, 2},
+			"[]uint64":  []uint64{1, 2},
+			"[]float32": []float32{1, 2},
+		}
+		changed, err := av.Audit(cpy, obj, updateSignature)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !changed {
+			t.Fatal("Audit() returned false when all numeric values were changed")
+		}
+		if got, want := len(av.history[1].fields), len(obj.Values); got != want {
+			t.Errorf("Audit() recorded %d changed fields, want %d", got, want)
+		}
+	}
+
+	// Values of the same number but different types are not equal
+	{
+		cpy := obj.Copy()
+		obj.Values["int8"] = int16(2)
+		changed, err := av.Audit(cpy, obj, getSig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !changed {
+			t.Fatal("Audit() returned false when the type of a value was changed")
+		}
+	}
+
+	// Serialization preserves the exact types
+	var deserialized AuditableValues
+	if b, err := av.Serialize(); err != nil {
+		t.Fatalf("Serialize() error: %v", err)
+	} else if err := deserialized.Deserialize(b); err != nil {
+		t.Fatalf("Deserialize() error: %v", err)
+	}
+
+	tRollback := updateSignature.Timestamp().Add(-time.Second)
+	if err := deserialized.RollbackTo(obj, tRollback); err != nil {
+		t.Fatal(err)
+	}
+	want := stateAtCreation.Copy()
+	want.tRollback = tRollback
+	if !reflect.DeepEqual(want, obj) {
+		t.Errorf("Wrong state after RollbackTo()\nWant %v\nGot  %v", want, obj)
+	}
+}
+
+// Tests that rolled back objects can be rolled forward again using the snapshot of the latest state
+func TestAuditableValuesRollForward(t *testing.T) {
+	getSig := new(signatureGenerator).Next
+
+	obj := &auditableObject{Values: map[string]interface{}{"A": "a0", "B": "b0"}}
+	var av AuditableValues
+	if _, err := av.Audit(nil, obj, getSig()); err != nil {
+		t.Fatal(err)
+	}
+	states := []*auditableObject{obj.Copy()}
+	var timestamps []time.Time
+	for i := 1; i <= 3; i++ {
+		cpy := obj.Copy()
+		obj.Values = map[string]interface{}{"A": fmt.Sprintf("a%d", i)}
+		if i%2 == 0 {
+			obj.Values["B"] = fmt.Sprintf("b%d", i)
+		}
+		sig := getSig()
+		if _, err := av.Audit(cpy, obj, sig); err != nil {
+			t.Fatal(err)
+		}
+		states = append(states, obj.Copy())
+		timestamps = append(timestamps, sig.Timestamp())
+	}
+	current := obj.Copy()
+
+	// Roll back to the creation and then forward to each update, making sure the
+	// snapshot survives serialization
+	var deserialized AuditableValues
+	if b, err := av.Serialize(); err != nil {
+		t.Fatalf("Serialize() error: %v", err)
+	} else if err := deserialized.Deserialize(b); err != nil {
+		t.Fatalf("Deserialize() error: %v", err)
+	}
+	for _, av := range []AuditableValues{av, deserialized} {
+		if err := av.RollbackTo(obj, timestamps[0].Add(-time.Second)); err != nil {
+			t.Fatal(err)
+		}
+		for i, tRollback := range timestamps {
+			if err := av.RollbackTo(obj, tRollback); err != nil {
+				t.Fatalf("RollbackTo(update %d) error: %v", i+1, err)
+			}
+			want := states[i+1].Copy()
+			want.tRollback = tRollback
+			if !reflect.DeepEqual(want, obj) {
+				t.Fatalf("Wrong state after rolling forward to update %d\nWant %v\nGot  %v", i+1, want, obj)
+			}
+		}
+
+		// Restore the current state
+		if err := av.Restore(obj); err != nil {
+			t.Fatalf("Restore() error: %v", err)
+		}
+		if !reflect.DeepEqual(current, obj) {
+			t.Fatalf("Wrong state after Restore()\nWant %v\nGot  %v", current, obj)
+		}
+	}
+
+	// Histories serialized in version 1 have no snapshot
+	av.latest = nil
+	b := serializeVersion(t, &av, 1)
+	var v1 AuditableValues
+	if err := v1.Deserialize(b); err != nil {
+		t.Fatalf("Deserialize(version 1) error: %v", err)
+	}
+	if !reflect.DeepEqual(av, v1) {
+		t.Errorf("Wrong value after deserializing version 1\nWant %v\nGot  %v", av, v1)
+	}
+	wantErr := fmt.Errorf("cannot restore object: the history has no snapshot of the latest state")
+	if err := v1.Restore(obj); !gotError(wantErr, err) {
+		t.Errorf("Wrong error from Restore() without snapshot\nWant %v\nGot  %v", wantErr, err)
+	}
+}
+
+func TestAuditableValuesSerializationTimeZones(t *testing.T) {
+	oslo, err := time.LoadLocation("Europe/Oslo")
+	if err != nil {
+		t.Skipf("time zone database not available: %v", err)
+	}
+	var (
+		tUTC   = time.Date(2010, 1, 2, 3, 4, 5, 6, time.UTC)
+		tOslo  = time.Date(2010, 7, 2, 3, 4, 5, 6, oslo)
+		tFixed = time.Date(2010, 1, 2, 3, 4, 5, 6, time.FixedZone("XYZ", -3*3600))
+	)
+	var av AuditableValues
+	av.addHistory(new(signatureGenerator).Next(), Field{"times", []time.Time{tUTC, tOslo, tFixed}})
+	b, err := av.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error: %v", err)
+	}
+	var got AuditableValues
+	if err := got.Deserialize(b); err != nil {
+		t.Fatalf("Deserialize() error: %v", err)
+	}
+	gotTimes := got.history[0].fields[0].Value.([]time.Time)
+	for i, want := range []time.Time{tUTC, tOslo, tFixed} {
+		gotTime := gotTimes[i]
+		if !gotTime.Equal(want) || gotTime.Location().String() != want.Location().String() {
+			t.Errorf("Wrong time after serialize/deserialize: want %s (%s), got %s (%s)",
+				want, want.Location(), gotTime, gotTime.Location())
+		}
+		gotName, gotOffset := gotTime.Zone()
+		wantName, wantOffset := want.Zone()
+		if gotName != wantName || gotOffset != wantOffset {
+			t.Errorf("Wrong zone after serialize/deserialize: want %s %d, got %s %d",
+				wantName, wantOffset, gotName, gotOffset)
+		}
+	}
+}
+
 func TestAuditableValuesLatestSignatureForField(t *testing.T) {
 	getSig := new(signatureGenerator).Next
 
@@ -893,6 +1135,16 @@ func TestAuditableValuesSignaturesForField(t *testing.T) {
 	}
 }
 
+// serializeVersion serializes values in the given format version.
+func serializeVersion(t *testing.T, values *AuditableValues, version byte) []byte {
+	var b bytes.Buffer
+	var buf bufrw.Buffer
+	if err := values.serializeVersionTo(buf.Writer(&b), version); err != nil {
+		t.Fatalf("serializeVersionTo(%d) error: %v", version, err)
+	}
+	return b.Bytes()
+}
+
 type signatureGenerator struct {
 	Auditor Auditor
 	counter int
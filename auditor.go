@@ -2,7 +2,9 @@ package audit
 
 import (
 	"fmt"
+	"net/url"
 	"strings"
+	"unicode/utf8"
 )
 type AuditorKind string
 
@@ -11,11 +13,23 @@ type Auditor struct {
 	id string
 }
 
-func NewAuditor(kind AuditorKind, id string) Auditor {
-	if strings.Contains(string(kind), "/") || strings.Contains(id, "/"){
-		panic("No slashes")
+// MakeAuditor returns the auditor with the given kind and id, which may be any valid
+// UTF-8 strings, including strings with slashes such as email addresses, URLs and
+// paths. It returns an error if kind or id is not valid UTF-8.
+func MakeAuditor(kind AuditorKind, id string) (Auditor, error) {
+	if !utf8.ValidString(string(kind)) {
+		return Auditor{}, fmt.Errorf("invalid auditor kind %q: not valid UTF-8", kind)
+	}
+	if !utf8.ValidString(id) {
+		return Auditor{}, fmt.Errorf("invalid auditor id %q: not valid UTF-8", id)
 	}
-	return Auditor{kind:kind,id:id}
+	return Auditor{kind: kind, id: id}, nil
+}
+
+// NewAuditor returns the auditor with the given kind and id without validating
+// them. Kinds and ids from untrusted input should be passed to MakeAuditor instead.
+func NewAuditor(kind AuditorKind, id string) Auditor {
+	return Auditor{kind: kind, id: id}
 }
 
 func (auditor Auditor) Kind() AuditorKind {
@@ -34,12 +48,35 @@ func (auditor Auditor) Equal(actor2 Auditor) bool {
 	return auditor.kind == actor2.kind && auditor.id == actor2.id
 }
 
+// Encode returns the auditor as "kind/id". If kind or id contains a slash, they are
+// escaped as URL path segments and prefixed by a slash instead, as in
+// "/service/batch%2Fnightly". Since unescaped encodings have exactly one slash, the
+// escaped encoding cannot be mistaken for one.
 func (auditor Auditor) Encode() string {
-	return string(auditor.kind) + "/" + auditor.id
+	kind := string(auditor.kind)
+	if strings.Contains(kind, "/") || strings.Contains(auditor.id, "/") {
+		return "/" + url.PathEscape(kind) + "/" + url.PathEscape(auditor.id)
+	}
+	return kind + "/" + auditor.id
 }
 
+// Decode decodes an auditor encoded by Encode. Kinds and ids are not validated, so
+// that auditors of any bytes, such as those created by NewAuditor, can be decoded.
 func (auditor *Auditor) Decode(src string) error {
 	split := strings.Split(src, "/")
+	if len(split) == 3 && split[0] == "" {
+		kind, err := url.PathUnescape(split[1])
+		if err != nil {
+			return fmt.Errorf("invalid encoded actor: %s: %w", src, err)
+		}
+		id, err := url.PathUnescape(split[2])
+		if err != nil {
+			return fmt.Errorf("invalid encoded actor: %s: %w", src, err)
+		}
+		auditor.kind = AuditorKind(kind)
+		auditor.id = id
+		return nil
+	}
 	if len(split) != 2 {
 		return fmt.Errorf("invalid encoded actor: %s", src)
 	}
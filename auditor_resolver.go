@@ -0,0 +1,158 @@
+package audit
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// AuditorInfo holds display information about an auditor, which is looked up when
+// displaying histories rather than stored in every history entry.
+type AuditorInfo struct {
+	DisplayName string
+	Email       string
+	AvatarURL   string
+	// Deactivated is whether the auditor, such as the account of a user that has
+	// left, is no longer active.
+	Deactivated bool
+}
+
+// AuditorResolver looks up display information about auditors by their kind and id.
+// ResolveAuditor returns false if the auditor is unknown.
+type AuditorResolver interface {
+	ResolveAuditor(auditor Auditor) (info AuditorInfo, found bool, err error)
+}
+
+// MemoryAuditorResolver is an AuditorResolver holding the information in memory. It
+// is safe for concurrent use. The zero value resolves no auditors.
+type MemoryAuditorResolver struct {
+	mu    sync.RWMutex
+	infos map[Auditor]AuditorInfo
+}
+
+// NewMemoryAuditorResolver returns a MemoryAuditorResolver holding a copy of infos.
+func NewMemoryAuditorResolver(infos map[Auditor]AuditorInfo) *MemoryAuditorResolver {
+	r := &MemoryAuditorResolver{infos: make(map[Auditor]AuditorInfo, len(infos))}
+	for auditor, info := range infos {
+		r.infos[auditor] = info
+	}
+	return r
+}
+
+// Set sets the information about auditor.
+func (r *MemoryAuditorResolver) Set(auditor Auditor, info AuditorInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.infos == nil {
+		r.infos = make(map[Auditor]AuditorInfo)
+	}
+	r.infos[auditor] = info
+}
+
+// Delete removes the information about auditor.
+func (r *MemoryAuditorResolver) Delete(auditor Auditor) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.infos, auditor)
+}
+
+func (r *MemoryAuditorResolver) ResolveAuditor(auditor Auditor) (AuditorInfo, bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	info, found := r.infos[auditor]
+	return info, found, nil
+}
+
+// CachingAuditorResolver is an AuditorResolver that caches the results of another
+// resolver, such as one that looks up users in a remote directory, for a fixed
+// time. Unknown auditors are cached as well, while errors are not. It is safe for
+// concurrent use if the underlying resolver is.
+type CachingAuditorResolver struct {
+	resolver AuditorResolver
+	ttl      time.Duration
+	now      func() time.Time
+
+	mu      sync.Mutex
+	entries map[Auditor]cachedAuditorInfo
+}
+
+type cachedAuditorInfo struct {
+	info    AuditorInfo
+	found   bool
+	expires time.Time
+}
+
+// NewCachingAuditorResolver returns a CachingAuditorResolver that caches the results
+// of resolver for ttl. Results are cached until invalidated if ttl is zero or
+// negative.
+func NewCachingAuditorResolver(resolver AuditorResolver, ttl time.Duration) *CachingAuditorResolver {
+	return &CachingAuditorResolver{
+		resolver: resolver,
+		ttl:      ttl,
+		now:      time.Now,
+		entries:  make(map[Auditor]cachedAuditorInfo),
+	}
+}
+
+func (r *CachingAuditorResolver) ResolveAuditor(auditor Auditor) (AuditorInfo, bool, error) {
+	r.mu.Lock()
+	entry, ok := r.entries[auditor]
+	r.mu.Unlock()
+	if ok && (entry.expires.IsZero() || r.now().Before(entry.expires)) {
+		return entry.info, entry.found, nil
+	}
+
+	info, found, err := r.resolver.ResolveAuditor(auditor)
+	if err != nil {
+		return AuditorInfo{}, false, err
+	}
+	entry = cachedAuditorInfo{info: info, found: found}
+	if r.ttl > 0 {
+		entry.expires = r.now().Add(r.ttl)
+	}
+	r.mu.Lock()
+	r.entries[auditor] = entry
+	r.mu.Unlock()
+	return info, found, nil
+}
+
+// Invalidate removes auditor from the cache, so that it is resolved again.
+func (r *CachingAuditorResolver) Invalidate(auditor Auditor) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.entries, auditor)
+}
+
+// InvalidateAll empties the cache.
+func (r *CachingAuditorResolver) InvalidateAll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = make(map[Auditor]cachedAuditorInfo)
+}
+
+// FormatAuditor returns the display name of auditor as resolved by resolver,
+// followed by " (deactivated)" if the auditor is deactivated. It returns
+// auditor.String() if resolver is nil, if the auditor is unknown or has no display
+// name, or if resolving it fails, so that histories can be displayed even if the
+// resolver is unavailable.
+func FormatAuditor(auditor Auditor, resolver AuditorResolver) string {
+	if resolver == nil {
+		return auditor.String()
+	}
+	info, found, err := resolver.ResolveAuditor(auditor)
+	if err != nil || !found || info.DisplayName == "" {
+		return auditor.String()
+	}
+	if info.Deactivated {
+		return fmt.Sprintf("%s (deactivated)", info.DisplayName)
+	}
+	return info.DisplayName
+}
+
+// FormatSignature formats the signature like Signature.String, with the auditors
+// formatted by FormatAuditor.
+func FormatSignature(sig Signature, resolver AuditorResolver) string {
+	return sig.format(func(auditor Auditor) string {
+		return FormatAuditor(auditor, resolver)
+	})
+}
@@ -0,0 +1,147 @@
+package audit
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// countingResolver counts the lookups made through it.
+type countingResolver struct {
+	resolver AuditorResolver
+	err      error
+	lookups  int
+}
+
+func (r *countingResolver) ResolveAuditor(auditor Auditor) (AuditorInfo, bool, error) {
+	r.lookups++
+	if r.err != nil {
+		return AuditorInfo{}, false, r.err
+	}
+	return r.resolver.ResolveAuditor(auditor)
+}
+
+func TestMemoryAuditorResolver(t *testing.T) {
+	alice, bob := NewAuditor("user", "alice"), NewAuditor("user", "bob")
+	infos := map[Auditor]AuditorInfo{alice: {DisplayName: "Alice", Email: "alice@example.com"}}
+	r := NewMemoryAuditorResolver(infos)
+	delete(infos, alice)
+
+	if info, found, err := r.ResolveAuditor(alice); err != nil || !found || info.DisplayName != "Alice" {
+		t.Errorf("ResolveAuditor(alice) = %v, %v, %v, want Alice", info, found, err)
+	}
+	if _, found, err := r.ResolveAuditor(bob); err != nil || found {
+		t.Errorf("ResolveAuditor(bob) = %v, %v, want not found", found, err)
+	}
+	r.Set(bob, AuditorInfo{DisplayName: "Bob"})
+	if info, found, _ := r.ResolveAuditor(bob); !found || info.DisplayName != "Bob" {
+		t.Errorf("ResolveAuditor(bob) after Set() = %v, %v, want Bob", info, found)
+	}
+	r.Delete(alice)
+	if _, found, _ := r.ResolveAuditor(alice); found {
+		t.Errorf("ResolveAuditor(alice) after Delete() found the auditor")
+	}
+
+	var zero MemoryAuditorResolver
+	if _, found, err := zero.ResolveAuditor(alice); err != nil || found {
+		t.Errorf("ResolveAuditor() of zero value = %v, %v, want not found", found, err)
+	}
+	zero.Set(alice, AuditorInfo{DisplayName: "Alice"})
+	if _, found, _ := zero.ResolveAuditor(alice); !found {
+		t.Errorf("ResolveAuditor() of zero value after Set() did not find the auditor")
+	}
+}
+
+func TestCachingAuditorResolver(t *testing.T) {
+	alice, bob := NewAuditor("user", "alice"), NewAuditor("user", "bob")
+	memory := NewMemoryAuditorResolver(map[Auditor]AuditorInfo{alice: {DisplayName: "Alice"}})
+	counting := &countingResolver{resolver: memory}
+	r := NewCachingAuditorResolver(counting, time.Minute)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return now }
+
+	resolve := func(auditor Auditor, wantName string, wantLookups int) {
+		t.Helper()
+		info, found, err := r.ResolveAuditor(auditor)
+		if err != nil {
+			t.Fatalf("ResolveAuditor(%s) error: %v", auditor, err)
+		}
+		if found != (wantName != "") || info.DisplayName != wantName {
+			t.Errorf("ResolveAuditor(%s) = %q, %v, want %q", auditor, info.DisplayName, found, wantName)
+		}
+		if counting.lookups != wantLookups {
+			t.Errorf("ResolveAuditor(%s) made %d lookups in total, want %d", auditor, counting.lookups, wantLookups)
+		}
+	}
+
+	// Found and unknown auditors are cached
+	resolve(alice, "Alice", 1)
+	resolve(alice, "Alice", 1)
+	resolve(bob, "", 2)
+	memory.Set(bob, AuditorInfo{DisplayName: "Bob"})
+	resolve(bob, "", 2)
+
+	// Entries expire after the ttl
+	now = now.Add(time.Minute)
+	resolve(bob, "Bob", 3)
+	resolve(alice, "Alice", 4)
+
+	// Invalidated entries are resolved again
+	memory.Set(alice, AuditorInfo{DisplayName: "Alice Smith"})
+	r.Invalidate(alice)
+	resolve(alice, "Alice Smith", 5)
+	resolve(bob, "Bob", 5)
+	r.InvalidateAll()
+	resolve(bob, "Bob", 6)
+
+	// Errors are not cached
+	r.InvalidateAll()
+	counting.err = errors.New("directory unavailable")
+	if _, _, err := r.ResolveAuditor(alice); err == nil {
+		t.Errorf("ResolveAuditor() with failing resolver: want error, got nil")
+	}
+	counting.err = nil
+	resolve(alice, "Alice Smith", 8)
+
+	// Entries never expire without a ttl
+	r = NewCachingAuditorResolver(counting, 0)
+	r.now = func() time.Time { return now }
+	resolve(alice, "Alice Smith", 9)
+	now = now.Add(24 * time.Hour)
+	resolve(alice, "Alice Smith", 9)
+}
+
+func TestFormatAuditor(t *testing.T) {
+	alice, bob, carol := NewAuditor("user", "alice"), NewAuditor("user", "bob"), NewAuditor("user", "carol")
+	resolver := NewMemoryAuditorResolver(map[Auditor]AuditorInfo{
+		alice: {DisplayName: "Alice Smith"},
+		bob:   {DisplayName: "Bob Jones", Deactivated: true},
+		carol: {Email: "carol@example.com"},
+	})
+	tests := []struct {
+		auditor  Auditor
+		resolver AuditorResolver
+		want     string
+	}{
+		{alice, nil, "user/alice"},
+		{alice, resolver, "Alice Smith"},
+		{bob, resolver, "Bob Jones (deactivated)"},
+		{carol, resolver, "user/carol"},
+		{NewAuditor("user", "dave"), resolver, "user/dave"},
+		{alice, &countingResolver{err: errors.New("directory unavailable")}, "user/alice"},
+	}
+	for _, test := range tests {
+		if got := FormatAuditor(test.auditor, test.resolver); got != test.want {
+			t.Errorf("FormatAuditor(%s) = %q, want %q", test.auditor, got, test.want)
+		}
+	}
+
+	sig := NewSignature(bob, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), WithOnBehalfOf(alice), WithReason("Fix typo"))
+	want := "Bob Jones (deactivated)@2024-05-01T12:00:00Z on behalf of Alice Smith (Fix typo)"
+	if got := FormatSignature(sig, resolver); got != want {
+		t.Errorf("FormatSignature() = %q, want %q", got, want)
+	}
+	if got, want := FormatSignature(sig, nil), sig.String(); got != want {
+		t.Errorf("FormatSignature() without resolver = %q, want %q", got, want)
+	}
+}
@@ -2,6 +2,7 @@ package audit
 
 import (
 	"testing"
+	"time"
 )
 
 func TestAuditorEqual(t *testing.T) {
@@ -41,4 +42,86 @@ func TestAuditorEncodeDecode(t *testing.T) {
 			t.Errorf("Decode(%s) = %v, want %v", encoded, got, auditor)
 		}
 	}
-}
\ No newline at end of file
+}
+
+func TestAuditorEscapedEncoding(t *testing.T) {
+	tests := []struct {
+		auditor Auditor
+		encoded string
+	}{
+		// Auditors without slashes keep the unescaped encoding
+		{NewAuditor("user", "alice@example.com"), "user/alice@example.com"},
+		{NewAuditor("user", "50%"), "user/50%"},
+		{NewAuditor("", ""), "/"},
+		// Auditors with slashes are escaped
+		{NewAuditor("service", "batch/nightly"), "/service/batch%2Fnightly"},
+		{NewAuditor("url", "https://example.com/a b"), "/url/https:%2F%2Fexample.com%2Fa%20b"},
+		{NewAuditor("k/ind", "50%"), "/k%2Find/50%25"},
+		{NewAuditor("", "/"), "//%2F"},
+		{NewAuditor("bruker", "Åse/Ødegård"), "/bruker/%C3%85se%2F%C3%98deg%C3%A5rd"},
+		// Auditors that are not valid UTF-8 are encoded as they are
+		{NewAuditor("a", "\xff"), "a/\xff"},
+		{NewAuditor("\xff", "b"), "\xff/b"},
+		{NewAuditor("user", "caf\xe9/x"), "/user/caf%E9%2Fx"},
+	}
+	for _, test := range tests {
+		if got := test.auditor.Encode(); got != test.encoded {
+			t.Errorf("%q/%q.Encode() = %q, want %q", test.auditor.Kind(), test.auditor.ID(), got, test.encoded)
+		}
+		var got Auditor
+		if err := got.Decode(test.encoded); err != nil {
+			t.Fatalf("Decode(%q) error: %v", test.encoded, err)
+		}
+		if !got.Equal(test.auditor) {
+			t.Errorf("Decode(%q) = %q/%q, want %q/%q", test.encoded, got.Kind(), got.ID(), test.auditor.Kind(), test.auditor.ID())
+		}
+	}
+
+	for _, invalid := range []string{"", "kind", "a/b/c", "/a/b/c", "/a/%zz"} {
+		var got Auditor
+		if err := got.Decode(invalid); err == nil {
+			t.Errorf("Decode(%q): want error, got %q/%q", invalid, got.Kind(), got.ID())
+		}
+	}
+}
+
+func TestMakeAuditor(t *testing.T) {
+	auditor, err := MakeAuditor("service", "batch/nightly")
+	if err != nil {
+		t.Fatalf("MakeAuditor() error: %v", err)
+	}
+	if auditor.Kind() != "service" || auditor.ID() != "batch/nightly" {
+		t.Errorf("MakeAuditor() = %q/%q, want service/batch/nightly", auditor.Kind(), auditor.ID())
+	}
+	if _, err := MakeAuditor("service", "\xff"); err == nil {
+		t.Errorf("MakeAuditor() with invalid UTF-8 id: want error, got nil")
+	}
+	if _, err := MakeAuditor("\xff", "id"); err == nil {
+		t.Errorf("MakeAuditor() with invalid UTF-8 kind: want error, got nil")
+	}
+
+	// NewAuditor does not validate, and does not panic
+	if got := NewAuditor("service", "\xff"); got.ID() != "\xff" {
+		t.Errorf("NewAuditor() with invalid UTF-8 id = %q/%q", got.Kind(), got.ID())
+	}
+}
+
+func TestAuditorInvalidUTF8Serialize(t *testing.T) {
+	// Histories of auditors that are not valid UTF-8, which NewAuditor has always
+	// accepted, can be read in all formats
+	auditor := NewAuditor("user", "caf\xe9")
+	obj := &auditableObject{Values: map[string]interface{}{"A": 1}}
+	var av AuditableValues
+	if _, err := av.Audit(nil, obj, NewSignature(auditor, time.Date(2010, 1, 1, 12, 0, 0, 0, time.UTC))); err != nil {
+		t.Fatal(err)
+	}
+	for _, version := range []byte{1, serializationVersion} {
+		var got AuditableValues
+		if err := got.Deserialize(serializeVersion(t, &av, version)); err != nil {
+			t.Fatalf("Deserialize(version %d) error: %v", version, err)
+		}
+		if got := got.CreationSignature().Auditor(); !got.Equal(auditor) {
+			t.Errorf("Wrong auditor from version %d: %q, want %q", version, got, auditor)
+		}
+	}
+}
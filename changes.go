@@ -0,0 +1,227 @@
+package audit
+
+import (
+	"fmt"
+	"time"
+)
+
+// ChangeKind describes how a field was changed by an audit.
+type ChangeKind int
+
+const (
+	// FieldAdded means the field was not present before the audit.
+	FieldAdded ChangeKind = iota + 1
+	// FieldModified means the value of the field was changed by the audit.
+	FieldModified
+	// FieldRemoved means the field was not present after the audit.
+	FieldRemoved
+)
+
+func (kind ChangeKind) String() string {
+	switch kind {
+	case FieldAdded:
+		return "added"
+	case FieldModified:
+		return "modified"
+	case FieldRemoved:
+		return "removed"
+	default:
+		return fmt.Sprintf("<invalid change kind (%d)>", int(kind))
+	}
+}
+
+// Change describes the change of a single field. Old is nil for added fields
+// and New is nil for removed fields. Nested fields are named by their path.
+type Change struct {
+	Name string
+	Kind ChangeKind
+	Old  interface{}
+	New  interface{}
+}
+
+func (change Change) String() string {
+	switch change.Kind {
+	case FieldAdded:
+		return fmt.Sprintf("%s %s: %v", change.Kind, change.Name, change.New)
+	case FieldRemoved:
+		return fmt.Sprintf("%s %s: %v", change.Kind, change.Name, change.Old)
+	default:
+		return fmt.Sprintf("%s %s: %v -> %v", change.Kind, change.Name, change.Old, change.New)
+	}
+}
+
+// ChangeSet holds the changes of all fields changed by a single audit.
+type ChangeSet struct {
+	Signature Signature
+	Changes   []Change
+	// Baseline is true for the first change set of a compacted history (see
+	// Compact), which holds the state of the object as of the baseline rather than
+	// its creation. The fields were not necessarily set by the auditor of the
+	// signature, which is that of the latest audit squashed into the baseline.
+	Baseline bool
+}
+
+// Changes returns the changes made by each audit in the history, ordered ascending by
+// timestamp. Since the history only stores the values fields had before each audit,
+// the values after each audit are reconstructed from current, which must hold the
+// state of the object as of the latest audit.
+//
+// The first change set holds the creation of the object, where all fields present
+// at creation are reported as added. For compacted histories, it holds the fields
+// present at the baseline instead, and is marked as the Baseline.
+func (values *AuditableValues) Changes(current AuditableObject) ([]ChangeSet, error) {
+	if len(values.history) == 0 {
+		return nil, nil
+	}
+	state, tRollback, err := getFlattenedFields(current)
+	if err != nil {
+		return nil, err
+	}
+	if !tRollback.IsZero() {
+		return nil, fmt.Errorf("cannot get changes based on a rolled back object")
+	}
+	updates, state, err := values.changeSetsFrom(state, 1)
+	if err != nil {
+		return nil, err
+	}
+	creation := ChangeSet{
+		Signature: values.history[0].signature,
+		Changes:   make([]Change, len(state)),
+		Baseline:  values.IsTruncated(),
+	}
+	for i, field := range state {
+		creation.Changes[i] = Change{Name: field.Name, Kind: FieldAdded, New: field.Value}
+	}
+	return append([]ChangeSet{creation}, updates...), nil
+}
+
+// changeSetsFrom returns the changes made by the history entries from index start
+// onwards, given the state of the fields as of the latest entry, which is modified.
+// It also returns the state before the entry at index start.
+func (values *AuditableValues) changeSetsFrom(state fieldSlice, start int) ([]ChangeSet, fieldSlice, error) {
+	n := len(values.history)
+	changeSets := make([]ChangeSet, n-start)
+	for i := n - 1; i >= start; i-- {
+		h := values.history[i]
+		changes, err := h.changes(state)
+		if err != nil {
+			return nil, nil, err
+		}
+		changeSets[i-start] = ChangeSet{Signature: h.signature, Changes: changes}
+		h.undo(&state)
+	}
+	return changeSets, state, nil
+}
+
+// changes returns the changes made by the history entry, given the state of the
+// fields after the entry.
+func (h auditHistory) changes(after fieldSlice) ([]Change, error) {
+	changes := make([]Change, len(h.fields))
+	for i, field := range h.fields {
+		newField, hasNew := after.TryGet(field.Name)
+		switch {
+		case field.Value == magicValueFieldRemoved && !hasNew:
+			return nil, fmt.Errorf("inconsistent history: field %s added at %s is not present in the object",
+				field.Name, h.signature)
+		case field.Value == magicValueFieldRemoved:
+			changes[i] = Change{Name: field.Name, Kind: FieldAdded, New: newField.Value}
+		case !hasNew:
+			changes[i] = Change{Name: field.Name, Kind: FieldRemoved, Old: field.Value}
+		default:
+			changes[i] = Change{Name: field.Name, Kind: FieldModified, Old: field.Value, New: newField.Value}
+		}
+	}
+	return changes, nil
+}
+
+// FieldVersion holds the value of a field as set by a single audit. Present is
+// false if the field was not present after the audit.
+type FieldVersion struct {
+	Signature Signature
+	Value     interface{}
+	Present   bool
+}
+
+// FieldTimeline returns the versions of the named field over its complete life,
+// ordered ascending by timestamp. The first version holds the value of the field
+// at the creation of the object, and is followed by a version for every audit that
+// changed the field. The name of a nested field is its full path.
+//
+// As with Changes, the values are reconstructed from current, which must hold the
+// state of the object as of the latest audit.
+func (values *AuditableValues) FieldTimeline(current AuditableObject, name string) ([]FieldVersion, error) {
+	n := len(values.history)
+	if n == 0 {
+		return nil, nil
+	}
+	fields, tRollback, err := getFlattenedFields(current)
+	if err != nil {
+		return nil, err
+	}
+	if !tRollback.IsZero() {
+		return nil, fmt.Errorf("cannot get field timeline based on a rolled back object")
+	}
+	field, present := fields.TryGet(name)
+	value := field.Value
+	var timeline []FieldVersion
+	for i := n - 1; i >= 0; i-- {
+		h := values.history[i]
+		if i == 0 {
+			timeline = append(timeline, FieldVersion{Signature: h.signature, Value: value, Present: present})
+			break
+		}
+		field, ok := h.fields.TryGet(name)
+		if !ok {
+			continue
+		}
+		timeline = append(timeline, FieldVersion{Signature: h.signature, Value: value, Present: present})
+		if field.Value == magicValueFieldRemoved {
+			value, present = nil, false
+		} else {
+			value, present = field.Value, true
+		}
+	}
+	// Reverse the timeline to order it ascending by timestamp
+	for i, j := 0, len(timeline)-1; i < j; i, j = i+1, j-1 {
+		timeline[i], timeline[j] = timeline[j], timeline[i]
+	}
+	return timeline, nil
+}
+
+// ValueAt returns the value the named field had at time t, and whether the field
+// was present at that time. Unlike RollbackTo, only the history entries of the field
+// are examined, and current is not modified. As with RollbackTo, ErrDidNotExist is
+// returned if t is before the creation of the object (or ErrHistoryTruncated if it
+// is before the baseline of a compacted history), and current may be an object
+// that is rolled back to a time after t. An object rolled back to before t can
+// only be used if there is a snapshot of the state after t, since the history
+// only stores the values fields had before each audit.
+func (values *AuditableValues) ValueAt(current AuditableObject, name string, t time.Time) (value interface{}, existed bool, err error) {
+	if len(values.history) == 0 {
+		return nil, false, fmt.Errorf("invalid state: empty history")
+	}
+	if tCreation := values.history[0].signature.timestamp; t.Before(tCreation) {
+		return nil, false, values.errBeforeHistory()
+	}
+	fields, tRollback, err := getFlattenedFields(current)
+	if err != nil {
+		return nil, false, err
+	}
+	target := values.indexAt(t)
+	start, fields, err := values.rollbackStart(target, fields, tRollback, t)
+	if err != nil {
+		return nil, false, err
+	}
+	// The value at t is the value stored by the first entry after t that changed the
+	// field, since entries store the values fields had before they were applied
+	for _, h := range values.history[target+1 : start+1] {
+		if field, ok := h.fields.TryGet(name); ok {
+			if field.Value == magicValueFieldRemoved {
+				return nil, false, nil
+			}
+			return field.Value, true, nil
+		}
+	}
+	field, ok := fields.TryGet(name)
+	return field.Value, ok, nil
+}
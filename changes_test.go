@@ -0,0 +1,230 @@
+package audit
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestAuditableValuesChanges(t *testing.T) {
+	getSig := new(signatureGenerator).Next
+
+	obj := &auditableObject{Values: map[string]interface{}{
+		"status": "draft",
+		"price":  10,
+		"note":   "note",
+	}}
+	var av AuditableValues
+
+	creationSignature := getSig()
+	if _, err := av.Audit(nil, obj, creationSignature); err != nil {
+		t.Fatal(err)
+	}
+
+	updateSignature1 := getSig()
+	{
+		cpy := obj.Copy()
+		obj.Values = map[string]interface{}{
+			"status": "published", // Modified
+			"price":  12,          // Modified
+			// note is removed
+		}
+		if _, err := av.Audit(cpy, obj, updateSignature1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	updateSignature2 := getSig()
+	{
+		cpy := obj.Copy()
+		obj.Values = map[string]interface{}{
+			"status": "published",
+			"price":  12,
+			"tags":   []string{"new"}, // Added
+		}
+		if _, err := av.Audit(cpy, obj, updateSignature2); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := av.Changes(obj)
+	if err != nil {
+		t.Fatalf("Changes() error: %v", err)
+	}
+	want := []ChangeSet{
+		{Signature: creationSignature, Changes: []Change{
+			{Name: "note", Kind: FieldAdded, New: "note"},
+			{Name: "price", Kind: FieldAdded, New: 10},
+			{Name: "status", Kind: FieldAdded, New: "draft"},
+		}},
+		{Signature: updateSignature1, Changes: []Change{
+			{Name: "note", Kind: FieldRemoved, Old: "note"},
+			{Name: "price", Kind: FieldModified, Old: 10, New: 12},
+			{Name: "status", Kind: FieldModified, Old: "draft", New: "published"},
+		}},
+		{Signature: updateSignature2, Changes: []Change{
+			{Name: "tags", Kind: FieldAdded, New: []string{"new"}},
+		}},
+	}
+	for _, changeSet := range got {
+		sort.Slice(changeSet.Changes, func(i, j int) bool {
+			return changeSet.Changes[i].Name < changeSet.Changes[j].Name
+		})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Wrong changes returned\nWant %v\nGot  %v", want, got)
+	}
+
+	// Changes cannot be computed from a rolled back object
+	if err := av.RollbackTo(obj, updateSignature1.Timestamp()); err != nil {
+		t.Fatal(err)
+	}
+	wantErr := fmt.Errorf("cannot get changes based on a rolled back object")
+	if _, err := av.Changes(obj); !gotError(wantErr, err) {
+		t.Errorf("Wrong error returned for rolled back object\nWant %v\nGot  %v", wantErr, err)
+	}
+}
+
+func TestAuditableValuesChangesInconsistentHistory(t *testing.T) {
+	getSig := new(signatureGenerator).Next
+
+	var av AuditableValues
+	av.addHistory(getSig(), Field{Value: magicValueHistoryCreation})
+	sig := getSig()
+	av.addHistory(sig, Field{Name: "A", Value: magicValueFieldRemoved})
+
+	// Field A was added by the latest audit, but is not present in the object
+	obj := &auditableObject{}
+	wantErr := fmt.Errorf("inconsistent history: field A added at %s is not present in the object", sig)
+	if _, err := av.Changes(obj); !gotError(wantErr, err) {
+		t.Errorf("Wrong error returned for inconsistent history\nWant %v\nGot  %v", wantErr, err)
+	}
+}
+
+func TestAuditableValuesFieldTimeline(t *testing.T) {
+	getSig := new(signatureGenerator).Next
+
+	var (
+		sig0 = getSig() // Creation: email = a
+		sig1 = getSig() // Update 1: email = b, name changed
+		sig2 = getSig() // Update 2: only name changed
+		sig3 = getSig() // Update 3: email removed
+		sig4 = getSig() // Update 4: email = c
+	)
+	obj := &auditableObject{Values: map[string]interface{}{"email": "c", "name": "3"}}
+
+	var av AuditableValues
+	av.addHistory(sig0, Field{Value: magicValueHistoryCreation})
+	av.addHistory(sig1, Field{Name: "email", Value: "a"}, Field{Name: "name", Value: "1"})
+	av.addHistory(sig2, Field{Name: "name", Value: "2"})
+	av.addHistory(sig3, Field{Name: "email", Value: "b"})
+	av.addHistory(sig4, Field{Name: "email", Value: magicValueFieldRemoved})
+
+	got, err := av.FieldTimeline(obj, "email")
+	if err != nil {
+		t.Fatalf("FieldTimeline() error: %v", err)
+	}
+	want := []FieldVersion{
+		{Signature: sig0, Value: "a", Present: true},
+		{Signature: sig1, Value: "b", Present: true},
+		{Signature: sig3, Value: nil, Present: false},
+		{Signature: sig4, Value: "c", Present: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Wrong timeline returned\nWant %v\nGot  %v", want, got)
+	}
+
+	// A field that has never been present
+	got, err = av.FieldTimeline(obj, "other")
+	if err != nil {
+		t.Fatalf("FieldTimeline() error: %v", err)
+	}
+	want = []FieldVersion{{Signature: sig0}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Wrong timeline returned for unknown field\nWant %v\nGot  %v", want, got)
+	}
+}
+
+func TestAuditableValuesValueAt(t *testing.T) {
+	getSig := new(signatureGenerator).Next
+
+	var (
+		sig0 = getSig() // Creation: email = a
+		sig1 = getSig() // Update 1: email = b
+		sig2 = getSig() // Update 2: email removed
+		sig3 = getSig() // Update 3: email = c
+	)
+	obj := &auditableObject{Values: map[string]interface{}{"email": "c"}}
+
+	var av AuditableValues
+	av.addHistory(sig0, Field{Value: magicValueHistoryCreation})
+	av.addHistory(sig1, Field{Name: "email", Value: "a"})
+	av.addHistory(sig2, Field{Name: "email", Value: "b"})
+	av.addHistory(sig3, Field{Name: "email", Value: magicValueFieldRemoved})
+
+	tests := []struct {
+		name      string
+		t         time.Time
+		wantValue interface{}
+		wantOK    bool
+	}{
+		{name: "At creation", t: sig0.Timestamp(), wantValue: "a", wantOK: true},
+		{name: "Before update 1", t: sig1.Timestamp().Add(-time.Second), wantValue: "a", wantOK: true},
+		{name: "At update 1", t: sig1.Timestamp(), wantValue: "b", wantOK: true},
+		{name: "At update 2", t: sig2.Timestamp(), wantValue: nil, wantOK: false},
+		{name: "At update 3", t: sig3.Timestamp(), wantValue: "c", wantOK: true},
+		{name: "After update 3", t: sig3.Timestamp().Add(time.Second), wantValue: "c", wantOK: true},
+	}
+	for _, test := range tests {
+		value, ok, err := av.ValueAt(obj, "email", test.t)
+		if err != nil {
+			t.Fatalf("ValueAt(%s) error: %v", test.name, err)
+		}
+		if value != test.wantValue || ok != test.wantOK {
+			t.Errorf("ValueAt(%s) = %v, %v, want %v, %v", test.name, value, ok, test.wantValue, test.wantOK)
+		}
+	}
+
+	// The object is not modified
+	if want := map[string]interface{}{"email": "c"}; !reflect.DeepEqual(obj.Values, want) || !obj.tRollback.IsZero() {
+		t.Errorf("ValueAt() modified the object: %v", obj)
+	}
+
+	if _, _, err := av.ValueAt(obj, "email", sig0.Timestamp().Add(-time.Second)); err != ErrDidNotExist {
+		t.Errorf("ValueAt() before creation returned wrong error\nWant %v\nGot  %v", ErrDidNotExist, err)
+	}
+
+	// Objects rolled back to after t can be used, but not objects rolled back to before t
+	if err := av.RollbackTo(obj, sig2.Timestamp()); err != nil {
+		t.Fatal(err)
+	}
+	if value, ok, err := av.ValueAt(obj, "email", sig1.Timestamp()); err != nil || !ok || value != "b" {
+		t.Errorf("ValueAt() on rolled back object = %v, %v, %v, want b, true, nil", value, ok, err)
+	}
+	tAfterRollback := sig3.Timestamp()
+	wantErr := fmt.Errorf("object is already rolled back to a timestamp earlier than t (tRollback: %s, t: %s)",
+		sig2.Timestamp(), tAfterRollback)
+	if _, _, err := av.ValueAt(obj, "email", tAfterRollback); !gotError(wantErr, err) {
+		t.Errorf("Wrong error returned for t after rollback time\nWant %v\nGot  %v", wantErr, err)
+	}
+
+	// With a snapshot of the latest state, the value at t after the rollback time is
+	// found from the snapshot, as with RollbackTo
+	av.latest = fieldSlice{{Name: "email", Value: "c"}}
+	for _, test := range []struct {
+		t         time.Time
+		wantValue interface{}
+		wantOK    bool
+	}{
+		{t: sig2.Timestamp().Add(time.Second), wantValue: nil, wantOK: false},
+		{t: tAfterRollback, wantValue: "c", wantOK: true},
+	} {
+		value, ok, err := av.ValueAt(obj, "email", test.t)
+		if err != nil || value != test.wantValue || ok != test.wantOK {
+			t.Errorf("ValueAt(%s) on object rolled back to before t = %v, %v, %v, want %v, %v, nil",
+				test.t, value, ok, err, test.wantValue, test.wantOK)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package audit
+
+import "sort"
+
+// checkpoint holds a snapshot of the flattened fields as of a history entry.
+type checkpoint struct {
+	index  int
+	fields fieldSlice
+}
+
+// SetCheckpointInterval makes Audit store a snapshot of the object every n
+// history entries, starting with the creation entry. RollbackTo starts from the
+// nearest snapshot after the rollback time, so that the cost of rolling back is
+// bounded by n rather than by the length of the history, at the expense of the
+// size of the history. Checkpoints are disabled if n is zero or negative, which
+// is the default. The interval is serialized along with the history.
+//
+// Changing the interval does not affect checkpoints that are already stored.
+func (values *AuditableValues) SetCheckpointInterval(n int) {
+	if n < 0 {
+		n = 0
+	}
+	values.checkpointInterval = n
+}
+
+// CheckpointInterval returns the interval set with SetCheckpointInterval.
+func (values *AuditableValues) CheckpointInterval() int {
+	return values.checkpointInterval
+}
+
+// addCheckpoint stores fields as a checkpoint for the latest history entry, if
+// the entry is at the checkpoint interval.
+func (values *AuditableValues) addCheckpoint(fields fieldSlice) {
+	index := len(values.history) - 1
+	if values.checkpointInterval <= 0 || index%values.checkpointInterval != 0 {
+		return
+	}
+	values.checkpoints = append(values.checkpoints, checkpoint{index: index, fields: fields.copy()})
+}
+
+// nearestSnapshot returns the snapshot of the fields, either a checkpoint or the
+// latest state, with the lowest history index not before index. It returns nil
+// if there is no such snapshot. The returned fields must not be modified.
+func (values *AuditableValues) nearestSnapshot(index int) (int, fieldSlice) {
+	i := sort.Search(len(values.checkpoints), func(i int) bool {
+		return values.checkpoints[i].index >= index
+	})
+	if i < len(values.checkpoints) {
+		return values.checkpoints[i].index, values.checkpoints[i].fields
+	}
+	if values.latest != nil {
+		return len(values.history) - 1, values.latest
+	}
+	return -1, nil
+}
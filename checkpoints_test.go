@@ -0,0 +1,111 @@
+package audit
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAuditableValuesCheckpoints(t *testing.T) {
+	getSig := new(signatureGenerator).Next
+
+	obj := &auditableObject{Values: map[string]interface{}{"A": 0}}
+	var av AuditableValues
+	av.SetCheckpointInterval(3)
+	if _, err := av.Audit(nil, obj, getSig()); err != nil {
+		t.Fatal(err)
+	}
+	states := []*auditableObject{obj.Copy()}
+	timestamps := []time.Time{av.history[0].signature.Timestamp()}
+	for i := 1; i <= 7; i++ {
+		cpy := obj.Copy()
+		obj.Values = map[string]interface{}{"A": i}
+		if i%2 == 0 {
+			obj.Values[fmt.Sprintf("B%d", i)] = i
+		}
+		sig := getSig()
+		if _, err := av.Audit(cpy, obj, sig); err != nil {
+			t.Fatal(err)
+		}
+		states = append(states, obj.Copy())
+		timestamps = append(timestamps, sig.Timestamp())
+	}
+
+	// Checkpoints are stored for the creation and every third entry after it
+	var gotIndexes []int
+	for _, cp := range av.checkpoints {
+		gotIndexes = append(gotIndexes, cp.index)
+	}
+	if want := []int{0, 3, 6}; !reflect.DeepEqual(gotIndexes, want) {
+		t.Errorf("Wrong checkpoint indexes\nWant %v\nGot  %v", want, gotIndexes)
+	}
+
+	var deserialized AuditableValues
+	if b, err := av.Serialize(); err != nil {
+		t.Fatalf("Serialize() error: %v", err)
+	} else if err := deserialized.Deserialize(b); err != nil {
+		t.Fatalf("Deserialize() error: %v", err)
+	}
+	if !reflect.DeepEqual(av, deserialized) {
+		t.Errorf("Wrong value after serialize/deserialize\nWant %v\nGot  %v", av, deserialized)
+	}
+
+	for _, av := range []AuditableValues{av, deserialized} {
+		// Rolling back starts from the nearest checkpoint rather than from the object,
+		// so an object holding the wrong state is rolled back correctly. The latest
+		// entry is left out, as rolling back to it starts from the object itself.
+		for i, tRollback := range timestamps[:len(timestamps)-1] {
+			obj := &auditableObject{Values: map[string]interface{}{"A": "wrong"}}
+			if err := av.RollbackTo(obj, tRollback); err != nil {
+				t.Fatalf("RollbackTo(entry %d) error: %v", i, err)
+			}
+			want := states[i].Copy()
+			want.tRollback = tRollback
+			if !reflect.DeepEqual(want, obj) {
+				t.Errorf("Wrong state after rolling back to entry %d\nWant %v\nGot  %v", i, want, obj)
+			}
+		}
+
+		// Without the snapshot of the latest state, a rolled back object is rolled
+		// forward from the checkpoints
+		av.latest = nil
+		obj := states[len(states)-1].Copy()
+		if err := av.RollbackTo(obj, timestamps[1]); err != nil {
+			t.Fatal(err)
+		}
+		if err := av.RollbackTo(obj, timestamps[4]); err != nil {
+			t.Fatalf("RollbackTo(entry 4) error: %v", err)
+		}
+		want := states[4].Copy()
+		want.tRollback = timestamps[4]
+		if !reflect.DeepEqual(want, obj) {
+			t.Errorf("Wrong state after rolling forward to entry 4\nWant %v\nGot  %v", want, obj)
+		}
+	}
+}
+
+func TestAuditableValuesCheckpointInterval(t *testing.T) {
+	var av AuditableValues
+	if n := av.CheckpointInterval(); n != 0 {
+		t.Errorf("CheckpointInterval() = %d, want 0", n)
+	}
+	av.SetCheckpointInterval(10)
+	if n := av.CheckpointInterval(); n != 10 {
+		t.Errorf("CheckpointInterval() = %d, want 10", n)
+	}
+	av.SetCheckpointInterval(-1)
+	if n := av.CheckpointInterval(); n != 0 {
+		t.Errorf("CheckpointInterval() = %d, want 0", n)
+	}
+
+	// No checkpoints are stored when the interval is zero
+	getSig := new(signatureGenerator).Next
+	obj := &auditableObject{Values: map[string]interface{}{"A": 0}}
+	if _, err := av.Audit(nil, obj, getSig()); err != nil {
+		t.Fatal(err)
+	}
+	if len(av.checkpoints) != 0 {
+		t.Errorf("Checkpoints stored with interval 0: %v", av.checkpoints)
+	}
+}
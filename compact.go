@@ -0,0 +1,92 @@
+package audit
+
+import (
+	"fmt"
+	"time"
+)
+
+// ErrHistoryTruncated is the error returned when rolling back an object to before the
+// baseline of a compacted history. See Compact.
+var ErrHistoryTruncated = fmt.Errorf("the history before the given time has been compacted")
+
+// Compact squashes all history entries at or before the cutoff into a single baseline
+// entry, which replaces the creation entry. The baseline holds the signature of the
+// latest squashed entry, so that objects can still be rolled back to the cutoff and
+// any time after it, while rolling back to before the baseline returns
+// ErrHistoryTruncated. current must hold the state of the object as of the latest
+// audit. Compacting is a no-op if no entries other than the creation entry are at or
+// before the cutoff.
+//
+// Compaction starts a new hash chain at the baseline (see Verify). Since the
+// signatures made by SignLatest cover the hashes, the signatures of the remaining
+// entries no longer verify, and are removed.
+//
+// Note that after compaction, CreationSignature returns the signature of the baseline.
+func (values *AuditableValues) Compact(current AuditableObject, before time.Time) error {
+	if len(values.history) == 0 {
+		return fmt.Errorf("invalid state: empty history")
+	}
+	fields, tRollback, err := getFlattenedFields(current)
+	if err != nil {
+		return err
+	}
+	if !tRollback.IsZero() {
+		return fmt.Errorf("cannot compact based on a rolled back object")
+	}
+	baseline := values.indexAt(before)
+	if baseline <= 0 {
+		return nil
+	}
+
+	// Get the state of the object as of the baseline, which is stored as its checkpoint
+	for i := len(values.history) - 1; i > baseline; i-- {
+		values.history[i].undo(&fields)
+	}
+
+	history := make([]auditHistory, 0, len(values.history)-baseline)
+	history = append(history, auditHistory{
+		fields:    fieldSlice{{"", magicValueHistoryTruncated}},
+		signature: values.history[baseline].signature,
+	})
+	for _, h := range values.history[baseline+1:] {
+		history = append(history, auditHistory{fields: h.fields, signature: h.signature})
+	}
+	checkpoints := make([]checkpoint, 0, len(values.checkpoints))
+	if values.checkpointInterval > 0 {
+		checkpoints = append(checkpoints, checkpoint{index: 0, fields: fields})
+	}
+	for _, cp := range values.checkpoints {
+		if cp.index > baseline {
+			checkpoints = append(checkpoints, checkpoint{index: cp.index - baseline, fields: cp.fields})
+		}
+	}
+	if len(checkpoints) == 0 {
+		checkpoints = nil
+	}
+
+	compacted := AuditableValues{history: history}
+	if err := compacted.computeHashes(0); err != nil {
+		return err
+	}
+	values.history = compacted.history
+	values.checkpoints = checkpoints
+	return nil
+}
+
+// IsTruncated returns whether the history has been compacted. See Compact.
+func (values *AuditableValues) IsTruncated() bool {
+	if values.IsZero() {
+		return false
+	}
+	fields := values.history[0].fields
+	return len(fields) == 1 && fields[0].Value == magicValueHistoryTruncated
+}
+
+// errBeforeHistory returns the error for rolling back to before the first entry of the
+// history, which is either the creation or the baseline of a compacted history.
+func (values *AuditableValues) errBeforeHistory() error {
+	if values.IsTruncated() {
+		return ErrHistoryTruncated
+	}
+	return ErrDidNotExist
+}
@@ -0,0 +1,141 @@
+package audit
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAuditableValuesCompact(t *testing.T) {
+	getSig := new(signatureGenerator).Next
+
+	obj := &auditableObject{Values: map[string]interface{}{"A": 0}}
+	var av AuditableValues
+	av.SetCheckpointInterval(2)
+	if _, err := av.Audit(nil, obj, getSig()); err != nil {
+		t.Fatal(err)
+	}
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	states := []*auditableObject{obj.Copy()}
+	var sigs = []Signature{av.CreationSignature()}
+	for i := 1; i <= 5; i++ {
+		cpy := obj.Copy()
+		obj.Values = map[string]interface{}{"A": i}
+		if i%2 == 0 {
+			obj.Values[fmt.Sprintf("B%d", i)] = i
+		}
+		sig := getSig()
+		if _, err := av.Audit(cpy, obj, sig); err != nil {
+			t.Fatal(err)
+		}
+		if err := av.SignLatest(key); err != nil {
+			t.Fatal(err)
+		}
+		states = append(states, obj.Copy())
+		sigs = append(sigs, sig)
+	}
+	current := obj.Copy()
+
+	// Compacting to before the first update does nothing
+	before := av
+	if err := av.Compact(current, sigs[1].Timestamp().Add(-time.Second)); err != nil {
+		t.Fatalf("Compact() error: %v", err)
+	}
+	if !reflect.DeepEqual(before, av) {
+		t.Errorf("Compact() before the first update modified the history")
+	}
+
+	// Compact everything up to and including update 2, using a cutoff between the
+	// timestamps of update 2 and 3
+	cutoff := sigs[2].Timestamp().Add(time.Second)
+	if err := av.Compact(current, cutoff); err != nil {
+		t.Fatalf("Compact() error: %v", err)
+	}
+	if got, want := len(av.history), 4; got != want {
+		t.Errorf("Wrong number of history entries after Compact(): %d, want %d", got, want)
+	}
+	if !av.IsTruncated() {
+		t.Errorf("IsTruncated() = false after Compact()")
+	}
+	if got := av.CreationSignature(); got != sigs[2] {
+		t.Errorf("Wrong creation signature after Compact(): %v, want %v", got, sigs[2])
+	}
+	if err := av.Verify(); err != nil {
+		t.Errorf("Verify() error after Compact(): %v", err)
+	}
+	if changeSets, err := av.Changes(current); err != nil {
+		t.Errorf("Changes() error after Compact(): %v", err)
+	} else if !changeSets[0].Baseline || changeSets[1].Baseline {
+		t.Errorf("Only the first change set after Compact() should be the baseline")
+	}
+	for _, h := range av.history {
+		if h.keySignature != nil {
+			t.Errorf("Signature of entry %s not removed by Compact()", h.signature)
+		}
+	}
+	var gotIndexes []int
+	for _, cp := range av.checkpoints {
+		gotIndexes = append(gotIndexes, cp.index)
+	}
+	if want := []int{0, 2}; !reflect.DeepEqual(gotIndexes, want) {
+		t.Errorf("Wrong checkpoint indexes after Compact()\nWant %v\nGot  %v", want, gotIndexes)
+	}
+
+	var deserialized AuditableValues
+	if b, err := av.Serialize(); err != nil {
+		t.Fatalf("Serialize() error: %v", err)
+	} else if err := deserialized.Deserialize(b); err != nil {
+		t.Fatalf("Deserialize() error: %v", err)
+	}
+	if !reflect.DeepEqual(av, deserialized) {
+		t.Errorf("Wrong value after serialize/deserialize\nWant %v\nGot  %v", av, deserialized)
+	}
+
+	for _, av := range []AuditableValues{av, deserialized} {
+		// Objects can be rolled back to the cutoff and after it
+		tests := []struct {
+			t    time.Time
+			want *auditableObject
+		}{
+			{sigs[2].Timestamp(), states[2]},
+			{cutoff, states[2]},
+			{sigs[3].Timestamp(), states[3]},
+			{sigs[4].Timestamp(), states[4]},
+		}
+		for _, test := range tests {
+			obj := current.Copy()
+			if err := av.RollbackTo(obj, test.t); err != nil {
+				t.Fatalf("RollbackTo(%s) error: %v", test.t, err)
+			}
+			want := test.want.Copy()
+			want.tRollback = test.t
+			if !reflect.DeepEqual(want, obj) {
+				t.Errorf("Wrong state after rolling back to %s\nWant %v\nGot  %v", test.t, want, obj)
+			}
+		}
+
+		// Rolling back to before the baseline returns ErrHistoryTruncated, also for
+		// times before the original creation
+		for _, tRollback := range []time.Time{sigs[1].Timestamp(), sigs[0].Timestamp().Add(-time.Second)} {
+			if err := av.RollbackTo(current.Copy(), tRollback); err != ErrHistoryTruncated {
+				t.Errorf("Wrong error from rolling back to %s\nWant %v\nGot  %v", tRollback, ErrHistoryTruncated, err)
+			}
+			if _, _, err := av.ValueAt(current, "A", tRollback); err != ErrHistoryTruncated {
+				t.Errorf("Wrong error from ValueAt(%s)\nWant %v\nGot  %v", tRollback, ErrHistoryTruncated, err)
+			}
+		}
+	}
+
+	// Rolled back objects cannot be compacted
+	obj = current.Copy()
+	obj.tRollback = sigs[4].Timestamp()
+	wantErr := fmt.Errorf("cannot compact based on a rolled back object")
+	if err := av.Compact(obj, sigs[4].Timestamp()); !gotError(wantErr, err) {
+		t.Errorf("Wrong error from compacting a rolled back object\nWant %v\nGot  %v", wantErr, err)
+	}
+}
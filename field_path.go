@@ -0,0 +1,116 @@
+package audit
+
+import (
+	"strings"
+)
+
+// FieldPathSeparator separates the names of nested fields in a field path. A
+// field with a []Field value is audited as a nested object, where each of its
+// leaf fields is recorded under its full path, such as "address.city".
+//
+// Field names may contain the separator. Within paths, the separator and
+// FieldPathEscape are escaped with FieldPathEscape, so that the field "a.b" is
+// recorded as `a\.b`. Use FieldPath to build the path of such fields. Histories
+// serialized in format version 1, before fields could be nested, have the names
+// of their fields escaped when they are read, so that their fields are not
+// mistaken for nested fields.
+const FieldPathSeparator = "."
+
+// FieldPathEscape escapes FieldPathSeparator and itself in field names within
+// field paths. See FieldPathSeparator.
+const FieldPathEscape = `\`
+
+var fieldNameEscaper = strings.NewReplacer(FieldPathEscape, FieldPathEscape+FieldPathEscape,
+	FieldPathSeparator, FieldPathEscape+FieldPathSeparator)
+
+// FieldPath joins the names of nested fields into a field path, escaping the
+// separator in the names.
+func FieldPath(names ...string) string {
+	escaped := make([]string, len(names))
+	for i, name := range names {
+		escaped[i] = fieldNameEscaper.Replace(name)
+	}
+	return strings.Join(escaped, FieldPathSeparator)
+}
+
+// splitFieldPath is the inverse of FieldPath, returning the unescaped names of
+// the path.
+func splitFieldPath(path string) []string {
+	var names []string
+	var name strings.Builder
+	for i := 0; i < len(path); i++ {
+		switch {
+		case strings.HasPrefix(path[i:], FieldPathEscape) && i+len(FieldPathEscape) < len(path):
+			i += len(FieldPathEscape)
+			name.WriteByte(path[i])
+		case strings.HasPrefix(path[i:], FieldPathSeparator):
+			names = append(names, name.String())
+			name.Reset()
+		default:
+			name.WriteByte(path[i])
+		}
+	}
+	return append(names, name.String())
+}
+
+// fieldPathHasPrefix returns whether path equals prefix or is the path of a
+// field nested below prefix.
+func fieldPathHasPrefix(path, prefix string) bool {
+	return path == prefix || strings.HasPrefix(path, prefix+FieldPathSeparator)
+}
+
+// flattenFields returns the leaf fields of fields, where nested fields are named
+// by their full path.
+func flattenFields(fields []Field) fieldSlice {
+	flattened := make(fieldSlice, 0, len(fields))
+	flattened.appendFlattened("", fields)
+	return flattened
+}
+
+func (s *fieldSlice) appendFlattened(prefix string, fields []Field) {
+	for _, field := range fields {
+		name := fieldNameEscaper.Replace(field.Name)
+		if prefix != "" {
+			name = prefix + FieldPathSeparator + name
+		}
+		var nested []Field
+		switch v := field.Value.(type) {
+		case []Field:
+			nested = v
+		case fieldSlice:
+			nested = v
+		default:
+			*s = append(*s, Field{Name: name, Value: field.Value})
+			continue
+		}
+		s.appendFlattened(name, nested)
+	}
+}
+
+// unflattenFields is the inverse of flattenFields, turning field paths back into
+// nested fields. Fields are ordered by the first appearance of their name.
+func unflattenFields(fields fieldSlice) []Field {
+	var nested []Field
+	for _, field := range fields {
+		nested = setNestedField(nested, splitFieldPath(field.Name), field.Value)
+	}
+	return nested
+}
+
+func setNestedField(fields []Field, path []string, value interface{}) []Field {
+	index := fieldSlice(fields).IndexOf(path[0])
+	if len(path) == 1 {
+		if index == -1 {
+			return append(fields, Field{Name: path[0], Value: value})
+		}
+		fields[index].Value = value
+		return fields
+	}
+	if index == -1 {
+		fields = append(fields, Field{Name: path[0]})
+		index = len(fields) - 1
+	}
+	children, _ := fields[index].Value.([]Field)
+	fields[index].Value = setNestedField(children, path[1:], value)
+	return fields
+}
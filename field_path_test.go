@@ -0,0 +1,190 @@
+package audit
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFlattenFields(t *testing.T) {
+	nested := []Field{
+		{"name", "customer"},
+		{"address", []Field{
+			{"city", "Oslo"},
+			{"geo", []Field{
+				{"lat", 59.9},
+				{"lng", 10.7},
+			}},
+		}},
+		{"tags", []string{"a"}},
+		{"v1.2", []Field{
+			{`a\b`, 1},
+		}},
+	}
+	flat := fieldSlice{
+		{"name", "customer"},
+		{"address.city", "Oslo"},
+		{"address.geo.lat", 59.9},
+		{"address.geo.lng", 10.7},
+		{"tags", []string{"a"}},
+		{`v1\.2.a\\b`, 1},
+	}
+
+	if got := flattenFields(nested); !reflect.DeepEqual(got, flat) {
+		t.Errorf("Wrong flattened fields\nWant %v\nGot  %v", flat, got)
+	}
+
+	if got := unflattenFields(flat); !reflect.DeepEqual(got, nested) {
+		t.Errorf("Wrong unflattened fields\nWant %v\nGot  %v", nested, got)
+	}
+}
+
+func TestFieldPath(t *testing.T) {
+	tests := []struct {
+		names []string
+		path  string
+	}{
+		{[]string{"a"}, "a"},
+		{[]string{"a", "b"}, "a.b"},
+		{[]string{"a.b"}, `a\.b`},
+		{[]string{"a.b", "c"}, `a\.b.c`},
+		{[]string{`a\`, "b"}, `a\\.b`},
+		{[]string{""}, ""},
+	}
+	for _, test := range tests {
+		if got := FieldPath(test.names...); got != test.path {
+			t.Errorf("FieldPath(%q) = %q, want %q", test.names, got, test.path)
+		}
+		if got := splitFieldPath(test.path); !reflect.DeepEqual(got, test.names) {
+			t.Errorf("splitFieldPath(%q) = %q, want %q", test.path, got, test.names)
+		}
+	}
+}
+
+func TestAuditableValuesDottedFieldNames(t *testing.T) {
+	getSig := new(signatureGenerator).Next
+
+	// Objects audited before fields could be nested may have dots in their field names
+	obj := &auditableObject{Values: map[string]interface{}{"a.b": 1, "c": 1}}
+	var av AuditableValues
+	if _, err := av.Audit(nil, obj, getSig()); err != nil {
+		t.Fatal(err)
+	}
+	stateAtCreation := obj.Copy()
+	cpy := obj.Copy()
+	obj.Values = map[string]interface{}{"a.b": 2, "c": 1}
+	update := getSig()
+	if _, err := av.Audit(cpy, obj, update); err != nil {
+		t.Fatal(err)
+	}
+	if got := av.LatestSignatureForField(FieldPath("a.b")); !got.Equal(update) {
+		t.Errorf("LatestSignatureForField(%s) = %v, want %v", FieldPath("a.b"), got, update)
+	}
+
+	// Histories in format version 1, where the names were not escaped, are read with
+	// the names escaped. Without that, the field a.b would be rolled back as the
+	// field b nested in a.
+	b := serializeVersion(t, &av, 1)
+	if !bytes.Contains(b, []byte("a.b")) || bytes.Contains(b, []byte(`a\.b`)) {
+		t.Fatalf("Version 1 does not hold the unescaped field name")
+	}
+	for _, version := range []byte{1, serializationVersion} {
+		var got AuditableValues
+		if err := got.Deserialize(serializeVersion(t, &av, version)); err != nil {
+			t.Fatalf("Deserialize(version %d) error: %v", version, err)
+		}
+		if !reflect.DeepEqual(got.history, av.history) {
+			t.Errorf("Wrong history from version %d\nWant %v\nGot  %v", version, av.history, got.history)
+		}
+		rolledBack := obj.Copy()
+		if err := got.RollbackTo(rolledBack, update.Timestamp().Add(-time.Second)); err != nil {
+			t.Fatalf("RollbackTo() error with version %d: %v", version, err)
+		}
+		if !reflect.DeepEqual(rolledBack.Values, stateAtCreation.Values) {
+			t.Errorf("Wrong state after RollbackTo() with version %d\nWant %v\nGot  %v",
+				version, stateAtCreation.Values, rolledBack.Values)
+		}
+	}
+}
+
+func TestAuditableValuesAuditAndRollbackNestedFields(t *testing.T) {
+	getSig := new(signatureGenerator).Next
+
+	obj := &auditableObject{
+		Values: map[string]interface{}{
+			"name": "customer",
+			"address": []Field{
+				{"city", "Oslo"},
+				{"zip", "0150"},
+			},
+		},
+	}
+	var av AuditableValues
+
+	creationSignature := getSig()
+	if _, err := av.Audit(nil, obj, creationSignature); err != nil {
+		t.Fatal(err)
+	}
+	stateAtCreation := obj.Copy()
+
+	updateSignature1 := getSig()
+	{
+		cpy := obj.Copy()
+		obj.Values["address"] = []Field{
+			{"city", "Bergen"},
+			{"zip", "0150"},
+		}
+		if _, err := av.Audit(cpy, obj, updateSignature1); err != nil {
+			t.Fatal(err)
+		}
+		// History is recorded per leaf
+		want := fieldSlice{{"address.city", "Oslo"}}
+		if got := av.history[1].fields; !reflect.DeepEqual(got, want) {
+			t.Errorf("Wrong history fields recorded\nWant %v\nGot  %v", want, got)
+		}
+	}
+	stateAfterUpdate1 := obj.Copy()
+
+	updateSignature2 := getSig()
+	{
+		cpy := obj.Copy()
+		obj.Values["name"] = "renamed"
+		if _, err := av.Audit(cpy, obj, updateSignature2); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Path prefixes match changes to nested fields
+	if got := av.LatestSignatureForField("address"); !got.Equal(updateSignature1) {
+		t.Errorf("LatestSignatureForField(address) = %v, want %v", got, updateSignature1)
+	}
+	if got := av.LatestSignatureForField("address.zip"); !got.Equal(creationSignature) {
+		t.Errorf("LatestSignatureForField(address.zip) = %v, want %v", got, creationSignature)
+	}
+	if got, want := av.SignaturesForField("address"), (SignatureSlice{creationSignature, updateSignature1}); !reflect.DeepEqual(got, want) {
+		t.Errorf("SignaturesForField(address)\nWant %v\nGot  %v", want, got)
+	}
+	if got, want := av.SignaturesForField("addr"), (SignatureSlice{creationSignature}); !reflect.DeepEqual(got, want) {
+		t.Errorf("SignaturesForField(addr)\nWant %v\nGot  %v", want, got)
+	}
+
+	tests := []struct {
+		name      string
+		tRollback time.Time
+		want      *auditableObject
+	}{
+		{name: "Before update 2", tRollback: updateSignature2.Timestamp().Add(-time.Second), want: stateAfterUpdate1},
+		{name: "Before update 1", tRollback: updateSignature1.Timestamp().Add(-time.Second), want: stateAtCreation},
+	}
+	for _, test := range tests {
+		if err := av.RollbackTo(obj, test.tRollback); err != nil {
+			t.Fatalf("RollbackTo(%s) error: %v", test.name, err)
+		}
+		want := test.want.Copy()
+		want.tRollback = test.tRollback
+		if !reflect.DeepEqual(want, obj) {
+			t.Fatalf("Wrong state after RollbackTo(%s)\nWant %v\nGot  %v", test.name, want, obj)
+		}
+	}
+}
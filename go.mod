@@ -2,4 +2,4 @@ module github.com/snechholt/audit
 
 go 1.20
 
-require github.com/snechholt/bufrw v0.1.0 // indirect
+require github.com/snechholt/bufrw v0.1.0
@@ -0,0 +1,186 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"github.com/snechholt/bufrw"
+	"sort"
+	"time"
+)
+
+// HashChainError is the error returned by Verify when the hash stored for a history
+// entry does not match the hash computed from the entry and the entry before it.
+type HashChainError struct {
+	// Index is the index of the first entry that breaks the chain, where the creation
+	// of the object is entry 0.
+	Index int
+	// Signature is the signature of the entry that breaks the chain.
+	Signature Signature
+}
+
+func (err *HashChainError) Error() string {
+	return fmt.Sprintf("hash chain is broken at history entry %d (%s)", err.Index, err.Signature)
+}
+
+// SnapshotError is the error returned by Verify when a stored snapshot of the fields
+// does not match the state given by undoing the history from the latest snapshot.
+type SnapshotError struct {
+	// Index is the index of the history entry of the first snapshot, from the latest
+	// backwards, that does not match.
+	Index int
+}
+
+func (err *SnapshotError) Error() string {
+	return fmt.Sprintf("snapshot of history entry %d does not match the history", err.Index)
+}
+
+// Verify verifies the hash chain over the history, and returns a *HashChainError for
+// the first entry whose stored hash does not match its content, which means that the
+// entry, or the entry before it, has been modified after it was recorded.
+//
+// Every history entry carries the SHA-256 hash of the hash of the entry before it,
+// and the signature and fields of the entry itself. The hashes are computed when the
+// entries are audited and are stored by SerializeTo. Histories deserialized from a
+// format without hashes have their hashes computed when they are read, so the chain
+// only covers changes made after that.
+//
+// The snapshots used by RollbackTo, Restore and Merge are not part of the chain.
+// Instead, Verify undoes the history from the snapshot of the latest state and
+// returns a *SnapshotError for the first checkpoint that does not match. Changes to
+// the snapshot of the latest state are therefore only detected in fields that were
+// not changed after the last checkpoint.
+func (values *AuditableValues) Verify() error {
+	var prev []byte
+	for i, h := range values.history {
+		hash, err := h.chainHash(prev)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(hash, h.hash) {
+			return &HashChainError{Index: i, Signature: h.signature}
+		}
+		prev = h.hash
+	}
+	return values.verifySnapshots()
+}
+
+// verifySnapshots undoes the history from the latest snapshot, or the last checkpoint
+// if there is none, and compares the state with the checkpoints on the way.
+func (values *AuditableValues) verifySnapshots() error {
+	checkpoints := values.checkpoints
+	start, fields := len(values.history)-1, values.latest
+	if fields == nil {
+		if len(checkpoints) == 0 {
+			return nil
+		}
+		last := checkpoints[len(checkpoints)-1]
+		start, fields, checkpoints = last.index, last.fields, checkpoints[:len(checkpoints)-1]
+	}
+	fields = fields.copy()
+	for i, j := start, len(checkpoints)-1; j >= 0; i-- {
+		if cp := checkpoints[j]; cp.index == i {
+			if !equalFieldSlices(cp.fields, fields) {
+				return &SnapshotError{Index: i}
+			}
+			j--
+		}
+		if i == 0 {
+			break
+		}
+		values.history[i].undo(&fields)
+	}
+	return nil
+}
+
+// chainHash returns the hash of the entry given the hash of the previous entry, which
+// is nil for the creation entry. The fields are hashed in order of their names, so
+// that the hash does not depend on the order they were recorded in.
+func (h auditHistory) chainHash(prev []byte) ([]byte, error) {
+	fields := h.fields.copy()
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Name < fields[j].Name })
+
+	var b bytes.Buffer
+	var buf bufrw.Buffer
+	w := buf.Writer(&b)
+	if err := w.WriteByteValues(prev...); err != nil {
+		return nil, err
+	}
+	if err := w.WriteString(h.signature.auditor.Encode()); err != nil {
+		return nil, err
+	}
+	var unixNano int64
+	if t := h.signature.timestamp; !t.IsZero() {
+		unixNano = t.UnixNano()
+	}
+	if err := w.WriteInt64(unixNano); err != nil {
+		return nil, err
+	}
+	if err := w.WriteInt(len(fields)); err != nil {
+		return nil, err
+	}
+	for _, field := range fields {
+		if err := w.WriteString(field.Name); err != nil {
+			return nil, err
+		}
+		if err := writeHashValue(w, field.Value); err != nil {
+			return nil, err
+		}
+	}
+	// The metadata is only hashed if present, which keeps the hashes of entries
+	// audited before signatures had metadata
+	if metadata := h.signature.metadata; metadata != nil {
+		if err := writeSignatureMetadata(w, metadata); err != nil {
+			return nil, err
+		}
+	}
+	hash := sha256.Sum256(b.Bytes())
+	return hash[:], nil
+}
+
+// writeHashValue writes value for hashing as writeValue does, except that times are
+// written as the instant and zone offset only. The location of a time is not
+// always restored when it is deserialized (see restoreLocation), so hashing its
+// name would break the chain.
+func writeHashValue(w *bufrw.Writer, value interface{}) error {
+	switch v := value.(type) {
+	case time.Time:
+		if err := w.WriteByteValue(valueTypeTime); err != nil {
+			return err
+		}
+		return writeHashTime(w, v)
+	case []time.Time:
+		if err := w.WriteByteValue(valueTypeTimes); err != nil {
+			return err
+		}
+		return writeSlice(w, v, func(t time.Time) error { return writeHashTime(w, t) })
+	}
+	return writeValue(w, value)
+}
+
+func writeHashTime(w *bufrw.Writer, t time.Time) error {
+	_, offset := t.Zone()
+	if err := w.WriteInt64(t.Unix()); err != nil {
+		return err
+	}
+	if err := w.WriteInt(t.Nanosecond()); err != nil {
+		return err
+	}
+	return w.WriteInt(offset)
+}
+
+// computeHashes computes the hashes of the entries from index start onwards.
+func (values *AuditableValues) computeHashes(start int) error {
+	for i := start; i < len(values.history); i++ {
+		var prev []byte
+		if i > 0 {
+			prev = values.history[i-1].hash
+		}
+		hash, err := values.history[i].chainHash(prev)
+		if err != nil {
+			return err
+		}
+		values.history[i].hash = hash
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAuditableValuesVerify(t *testing.T) {
+	getSig := new(signatureGenerator).Next
+
+	obj := &auditableObject{Values: map[string]interface{}{"amount": "amount-0", "note": "note"}}
+	var av AuditableValues
+	if _, err := av.Audit(nil, obj, getSig()); err != nil {
+		t.Fatal(err)
+	}
+	var sigs []Signature
+	for _, amount := range []string{"amount-1", "amount-2", "amount-3"} {
+		cpy := obj.Copy()
+		obj.Values["amount"] = amount
+		sig := getSig()
+		if _, err := av.Audit(cpy, obj, sig); err != nil {
+			t.Fatal(err)
+		}
+		sigs = append(sigs, sig)
+	}
+	if err := av.Verify(); err != nil {
+		t.Fatalf("Verify() error: %v", err)
+	}
+
+	b, err := av.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error: %v", err)
+	}
+	var got AuditableValues
+	if err := got.Deserialize(b); err != nil {
+		t.Fatalf("Deserialize() error: %v", err)
+	}
+	if err := got.Verify(); err != nil {
+		t.Errorf("Verify() error after serialize/deserialize: %v", err)
+	}
+
+	// Modifying a value in the serialized history breaks the chain at the entry holding
+	// the value. The history of the third audit holds the value set by the second.
+	tampered := bytes.Replace(b, []byte("amount-2"), []byte("amount-9"), 1)
+	if bytes.Equal(b, tampered) {
+		t.Fatal("Serialized history does not contain the value to tamper with")
+	}
+	if err := got.Deserialize(tampered); err != nil {
+		t.Fatalf("Deserialize() error: %v", err)
+	}
+	wantErr := &HashChainError{Index: 3, Signature: sigs[2]}
+	if err := got.Verify(); !reflect.DeepEqual(err, wantErr) {
+		t.Errorf("Wrong error from Verify() after tampering with a value\nWant %v\nGot  %v", wantErr, err)
+	}
+
+	// Removing an entry breaks the chain at the entry after it
+	got = av
+	got.history = append(append([]auditHistory(nil), av.history[:2]...), av.history[3:]...)
+	wantErr = &HashChainError{Index: 2, Signature: sigs[2]}
+	if err := got.Verify(); !reflect.DeepEqual(err, wantErr) {
+		t.Errorf("Wrong error from Verify() after removing an entry\nWant %v\nGot  %v", wantErr, err)
+	}
+
+	// The hashes of histories serialized without them are computed when they are read
+	if err := got.Deserialize(serializeVersion(t, &av, 1)); err != nil {
+		t.Fatalf("Deserialize(version 1) error: %v", err)
+	}
+	if !reflect.DeepEqual(av.history, got.history) {
+		t.Errorf("Wrong history after deserializing version 1\nWant %v\nGot  %v", av.history, got.history)
+	}
+	if err := got.Verify(); err != nil {
+		t.Errorf("Verify() error after deserializing version 1: %v", err)
+	}
+}
+
+func TestAuditableValuesVerifyCustomLocation(t *testing.T) {
+	// A zone named Custom/Zone at UTC+1, which is not in the time zone database
+	// and therefore restored as a fixed zone when deserialized
+	var tzdata bytes.Buffer
+	tzdata.WriteString("TZif")
+	tzdata.Write(make([]byte, 16)) // Version and unused bytes
+	for _, n := range []uint32{0, 0, 0, 0, 1, 4} {
+		tzdata.Write(binary.BigEndian.AppendUint32(nil, n)) // Counts of the sections
+	}
+	tzdata.Write(binary.BigEndian.AppendUint32(nil, 3600)) // Offset of the zone
+	tzdata.Write([]byte{0, 0})                             // Not DST, abbreviation index
+	tzdata.WriteString("XYZ\x00")
+	loc, err := time.LoadLocationFromTZData("Custom/Zone", tzdata.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	getSig := new(signatureGenerator).Next
+	t1 := time.Date(2010, 1, 1, 12, 0, 0, 0, loc)
+	obj := &auditableObject{Values: map[string]interface{}{"T": t1, "Ts": []time.Time{t1}}}
+	var av AuditableValues
+	if _, err := av.Audit(nil, obj, getSig()); err != nil {
+		t.Fatal(err)
+	}
+	cpy := obj.Copy()
+	obj.Values = map[string]interface{}{"T": t1.Add(time.Hour), "Ts": []time.Time{t1, t1.Add(time.Hour)}}
+	if _, err := av.Audit(cpy, obj, getSig()); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := av.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error: %v", err)
+	}
+	var got AuditableValues
+	if err := got.Deserialize(b); err != nil {
+		t.Fatalf("Deserialize() error: %v", err)
+	}
+	value, _ := got.history[1].fields.TryGet("T")
+	if name := value.Value.(time.Time).Location().String(); name != "XYZ" {
+		t.Fatalf("Deserialized time is in location %q, want the fixed zone XYZ", name)
+	}
+	if err := got.Verify(); err != nil {
+		t.Errorf("Verify() error after deserializing times in a custom location: %v", err)
+	}
+}
+
+func TestAuditableValuesVerifySnapshots(t *testing.T) {
+	getSig := new(signatureGenerator).Next
+
+	obj := &auditableObject{Values: map[string]interface{}{"amount": "amount-0", "note": "note-0"}}
+	var av AuditableValues
+	av.SetCheckpointInterval(2)
+	if _, err := av.Audit(nil, obj, getSig()); err != nil {
+		t.Fatal(err)
+	}
+	for _, amount := range []string{"amount-1", "amount-2", "amount-3"} {
+		cpy := obj.Copy()
+		obj.Values["amount"] = amount
+		if _, err := av.Audit(cpy, obj, getSig()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := av.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error: %v", err)
+	}
+	publicKey := func(Auditor) (ed25519.PublicKey, error) {
+		return nil, fmt.Errorf("no keys")
+	}
+
+	tests := []struct {
+		name     string
+		old, new string
+		last     bool
+	}{
+		// The checkpoint of entry 2 is the first value written for it, before the
+		// history of entry 3
+		{"checkpoint", "amount-2", "amount-9", false},
+		// The snapshot of the latest state is written last, in the footer
+		{"latest state", "note-0", "note-9", true},
+	}
+	for _, test := range tests {
+		i := bytes.Index(b, []byte(test.old))
+		if test.last {
+			i = bytes.LastIndex(b, []byte(test.old))
+		}
+		if i == -1 {
+			t.Fatalf("Serialized history does not contain %s", test.old)
+		}
+		tampered := append([]byte(nil), b...)
+		copy(tampered[i:], test.new)
+		var got AuditableValues
+		if err := got.Deserialize(tampered); err != nil {
+			t.Fatalf("Deserialize() error: %v", err)
+		}
+		wantErr := &SnapshotError{Index: 2}
+		if err := got.Verify(); !reflect.DeepEqual(err, wantErr) {
+			t.Errorf("Wrong error from Verify() after tampering with the %s\nWant %v\nGot  %v", test.name, wantErr, err)
+		}
+		if _, err := got.VerifySignatures(publicKey); !reflect.DeepEqual(err, wantErr) {
+			t.Errorf("Wrong error from VerifySignatures() after tampering with the %s\nWant %v\nGot  %v", test.name, wantErr, err)
+		}
+	}
+}
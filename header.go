@@ -0,0 +1,158 @@
+package audit
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/snechholt/bufrw"
+)
+
+// SerializedHeader holds summary information about serialized AuditableValues.
+type SerializedHeader struct {
+	CreationSignature Signature
+	LatestSignature   Signature
+	// EntryCount is the number of history entries, including the creation entry.
+	EntryCount int
+	// FieldNames holds the names of all fields of the object in the serialized
+	// values.
+	FieldNames []string
+}
+
+// ReadSerializedHeader reads the header of values serialized by Serialize. See
+// ReadSerializedHeaderFrom. Since format version 2, the header is read from the end
+// of b without reading the history entries at all.
+func ReadSerializedHeader(b []byte) (SerializedHeader, error) {
+	if len(b) > 0 && b[0] >= logVersion && b[0] <= serializationVersion {
+		footer, _, err := readFooterAt(b)
+		return footer.header, err
+	}
+	var buf bufrw.Buffer
+	return ReadSerializedHeaderFrom(buf.Reader(bytes.NewReader(b)))
+}
+
+// ReadSerializedHeaderFrom reads the header of values serialized by SerializeTo.
+// The entry records are skipped without decoding them, which makes it cheap to
+// list the signatures of many objects.
+//
+// Values serialized in format version 1 have no header, and are deserialized
+// completely to get the information.
+func ReadSerializedHeaderFrom(r *bufrw.Reader) (SerializedHeader, error) {
+	version, err := r.ReadByteValue()
+	if err != nil {
+		return SerializedHeader{}, err
+	}
+	if version < 1 || version > serializationVersion {
+		return SerializedHeader{}, fmt.Errorf("invalid version number: %d", version)
+	}
+	if version < logVersion {
+		var values AuditableValues
+		if err := values.deserializeVersionFrom(r, version); err != nil {
+			return SerializedHeader{}, err
+		}
+		return values.header(), nil
+	}
+	return readLogHeaderFrom(r)
+}
+
+// header returns the header of the values, as written by SerializeTo.
+func (values *AuditableValues) header() SerializedHeader {
+	header := SerializedHeader{
+		CreationSignature: values.CreationSignature(),
+		LatestSignature:   values.LatestSignature(),
+		EntryCount:        len(values.history),
+	}
+	var fieldNames stringSlice
+	addFieldNames := func(fields fieldSlice) {
+		for _, field := range fields {
+			if !field.isEntryMarker() && !fieldNames.Contains(field.Name) {
+				fieldNames = append(fieldNames, field.Name)
+			}
+		}
+	}
+	for _, h := range values.history {
+		addFieldNames(h.fields)
+	}
+	addFieldNames(values.latest)
+	for _, cp := range values.checkpoints {
+		addFieldNames(cp.fields)
+	}
+	header.FieldNames = fieldNames
+	return header
+}
+
+// isEntryMarker returns whether the field marks the creation or truncation of the
+// history, rather than recording the value of a field of the object.
+func (field Field) isEntryMarker() bool {
+	return field.Value == magicValueHistoryCreation || field.Value == magicValueHistoryTruncated
+}
+
+func (values *AuditableValues) writeHeader(w *bufrw.Writer) error {
+	n := len(values.history)
+	if err := w.WriteInt(n); err != nil {
+		return err
+	}
+	if n == 0 {
+		return nil
+	}
+	if err := w.WriteSerializable(&values.history[0].signature); err != nil {
+		return err
+	}
+	return w.WriteSerializable(&values.history[n-1].signature)
+}
+
+// readHeader reads the header written by writeHeader, without the field names.
+func readHeader(r *bufrw.Reader) (SerializedHeader, error) {
+	var header SerializedHeader
+	var err error
+	if header.EntryCount, err = r.ReadInt(); err != nil {
+		return SerializedHeader{}, err
+	}
+	if header.EntryCount < 0 {
+		return SerializedHeader{}, fmt.Errorf("invalid number of history entries: %d", header.EntryCount)
+	}
+	if header.EntryCount == 0 {
+		return header, nil
+	}
+	if err := r.ReadSerializable(&header.CreationSignature); err != nil {
+		return SerializedHeader{}, err
+	}
+	if err := r.ReadSerializable(&header.LatestSignature); err != nil {
+		return SerializedHeader{}, err
+	}
+	return header, nil
+}
+
+// maxTableLength is the maximum number of strings in the field name and auditor
+// tables, which keeps corrupt bytes from making readStringTable allocate
+// arbitrary amounts of memory.
+const maxTableLength = 1 << 20
+
+func readFieldNames(r *bufrw.Reader) ([]string, error) {
+	return readStringTable(r, "field names")
+}
+
+// readStringTable reads strings written by WriteStrings, checking the number of
+// strings against maxTableLength. what describes the strings in errors.
+func readStringTable(r *bufrw.Reader, what string) ([]string, error) {
+	n, err := r.ReadInt()
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 || n > maxTableLength {
+		return nil, fmt.Errorf("invalid number of %s: %d", what, n)
+	}
+	// The slice is grown as the strings are read rather than allocated up front, so
+	// that a large count in truncated bytes fails before allocating much
+	capacity := n
+	if capacity > 64 {
+		capacity = 64
+	}
+	table := make([]string, 0, capacity)
+	for i := 0; i < n; i++ {
+		s, err := r.ReadString()
+		if err != nil {
+			return nil, err
+		}
+		table = append(table, s)
+	}
+	return table, nil
+}
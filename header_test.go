@@ -0,0 +1,144 @@
+package audit
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/snechholt/bufrw"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestReadSerializedHeader(t *testing.T) {
+	getSig := new(signatureGenerator).Next
+
+	obj := &auditableObject{Values: map[string]interface{}{"A": 1}}
+	var av AuditableValues
+	creation := getSig()
+	if _, err := av.Audit(nil, obj, creation); err != nil {
+		t.Fatal(err)
+	}
+	cpy := obj.Copy()
+	obj.Values = map[string]interface{}{"A": 2, "B": "b"}
+	latest := getSig()
+	if _, err := av.Audit(cpy, obj, latest); err != nil {
+		t.Fatal(err)
+	}
+	b, err := av.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error: %v", err)
+	}
+
+	// The order of the field names depends on the order the fields were audited in,
+	// so they are sorted before comparing
+	want := SerializedHeader{
+		CreationSignature: creation,
+		LatestSignature:   latest,
+		EntryCount:        2,
+		FieldNames:        []string{"A", "B"},
+	}
+	got, err := ReadSerializedHeader(b)
+	if err != nil {
+		t.Fatalf("ReadSerializedHeader() error: %v", err)
+	}
+	if sort.Strings(got.FieldNames); !reflect.DeepEqual(got, want) {
+		t.Errorf("Wrong header\nWant %v\nGot  %v", want, got)
+	}
+
+	// Only the footer is read, so the entry records may be corrupt
+	corrupt := append([]byte(nil), b...)
+	_, footerStart, err := readFooterAt(corrupt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < footerStart; i++ {
+		corrupt[i] = 0xff
+	}
+	if got, err = ReadSerializedHeader(corrupt); err != nil {
+		t.Fatalf("ReadSerializedHeader() error on corrupt records: %v", err)
+	}
+	if sort.Strings(got.FieldNames); !reflect.DeepEqual(got, want) {
+		t.Errorf("Wrong header from corrupt records\nWant %v\nGot  %v", want, got)
+	}
+
+	// Reading from a stream skips the entry records
+	var buf bufrw.Buffer
+	if got, err = ReadSerializedHeaderFrom(buf.Reader(bytes.NewReader(b))); err != nil {
+		t.Fatalf("ReadSerializedHeaderFrom() error: %v", err)
+	}
+	if sort.Strings(got.FieldNames); !reflect.DeepEqual(got, want) {
+		t.Errorf("Wrong header from stream\nWant %v\nGot  %v", want, got)
+	}
+
+	// Empty histories have an empty header
+	var none AuditableValues
+	empty, err := none.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err = ReadSerializedHeader(empty); err != nil {
+		t.Fatalf("ReadSerializedHeader() error on empty history: %v", err)
+	}
+	if want := (SerializedHeader{FieldNames: []string{}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("Wrong header of empty history\nWant %v\nGot  %v", want, got)
+	}
+
+	// Formats without a header are deserialized
+	if got, err = ReadSerializedHeader(serializeVersion(t, &av, 1)); err != nil {
+		t.Fatalf("ReadSerializedHeader(version 1) error: %v", err)
+	}
+	if sort.Strings(got.FieldNames); !reflect.DeepEqual(got, want) {
+		t.Errorf("Wrong header from version 1\nWant %v\nGot  %v", want, got)
+	}
+
+	// The field name of the baseline entry of compacted histories is not included,
+	// also when the footer is rewritten by appending
+	if err := av.Compact(obj, latest.Timestamp()); err != nil {
+		t.Fatal(err)
+	}
+	if b, err = av.Serialize(); err != nil {
+		t.Fatal(err)
+	}
+	cpy = obj.Copy()
+	obj.Values = map[string]interface{}{"A": 3, "B": "b", "C": "c"}
+	if _, err := av.Audit(cpy, obj, getSig()); err != nil {
+		t.Fatal(err)
+	}
+	if b, err = av.AppendSerialized(b); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = ReadSerializedHeader(b); err != nil {
+		t.Fatalf("ReadSerializedHeader() error after Compact(): %v", err)
+	}
+	wantNames := []string{"A", "B", "C"}
+	if sort.Strings(got.FieldNames); !reflect.DeepEqual(got.FieldNames, wantNames) {
+		t.Errorf("Wrong field names after Compact()\nWant %v\nGot  %v", wantNames, got.FieldNames)
+	}
+	names := av.header().FieldNames
+	if sort.Strings(names); !reflect.DeepEqual(names, wantNames) {
+		t.Errorf("Wrong field names of header() after Compact()\nWant %v\nGot  %v", wantNames, names)
+	}
+}
+
+func TestReadFieldNamesInvalidCount(t *testing.T) {
+	for _, n := range []int{-1, maxTableLength + 1, 1 << 30} {
+		// Version 1 followed by the number of field names
+		var b bytes.Buffer
+		var buf bufrw.Buffer
+		w := buf.Writer(&b)
+		if err := w.WriteByteValue(1); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.WriteInt(n); err != nil {
+			t.Fatal(err)
+		}
+		wantErr := fmt.Errorf("invalid number of field names: %d", n)
+		var values AuditableValues
+		if err := values.Deserialize(b.Bytes()); !gotError(wantErr, err) {
+			t.Errorf("Wrong error from Deserialize() with %d field names\nWant %v\nGot  %v", n, wantErr, err)
+		}
+		if _, err := ReadSerializedHeader(b.Bytes()); !gotError(wantErr, err) {
+			t.Errorf("Wrong error from ReadSerializedHeader() with %d field names\nWant %v\nGot  %v", n, wantErr, err)
+		}
+	}
+}
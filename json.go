@@ -0,0 +1,471 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+)
+
+// The JSON encoding of AuditableValues holds the same information as the binary
+// serialization, and the two can be converted losslessly. The schema is:
+//
+//	{
+//	  "history": [
+//	    {
+//	      "signature": <Signature>,
+//	      "fields": [<field>, ...],
+//	      "hash": "<base64>",
+//	      "keySignature": "<base64>"             (omitted if the entry is not signed)
+//	    },
+//	    ...
+//	  ],
+//	  "latest": [<field>, ...],                  (omitted if there is no snapshot)
+//	  "checkpointInterval": <int>,               (omitted if 0)
+//	  "checkpoints": [{"index": <int>, "fields": [<field>, ...]}, ...] (omitted if none)
+//	  "valueCompression": <bool>                 (omitted if false)
+//	}
+//
+// A Signature is encoded as {"auditor": <Auditor>, "timestamp": "<RFC 3339>"}, with
+// "onBehalfOf": <Auditor>, "reason": "<reason>" and "attributes": {"<key>":
+// "<value>", ...} if set, and an Auditor as {"kind": "<kind>", "id": "<id>"}.
+//
+// Each field is encoded as {"name": "<name>", "type": "<type>", "value": <value>},
+// where type is the Go type of the value, such as "int64", "[]string" or
+// "time.Time", so that values are decoded to the type they were audited with.
+// Values are encoded as by encoding/json, with these exceptions:
+//
+//   - The creation entry and fields added by an audit hold a magic value of type
+//     "magic", with value "created" or "removed". The baseline of a compacted
+//     history holds the magic value "truncated".
+//   - Floats that are NaN or infinite are encoded as the strings "NaN", "+Inf" and
+//     "-Inf".
+//   - A time.Time is encoded as {"time": "<RFC 3339>", "location": "<name>",
+//     "zone": "<abbreviation>"}, which preserves its location like the binary format.
+//   - Values of registered types have type "registered" and an "id" with the id of
+//     the type, and the value is a base64 string holding the value encoded by the
+//     codec of the type.
+
+type jsonAuditableValues struct {
+	History            []jsonHistory    `json:"history"`
+	Latest             []jsonField      `json:"latest,omitempty"`
+	CheckpointInterval int              `json:"checkpointInterval,omitempty"`
+	Checkpoints        []jsonCheckpoint `json:"checkpoints,omitempty"`
+	ValueCompression   bool             `json:"valueCompression,omitempty"`
+}
+
+type jsonHistory struct {
+	Signature    Signature   `json:"signature"`
+	Fields       []jsonField `json:"fields"`
+	Hash         []byte      `json:"hash,omitempty"`
+	KeySignature []byte      `json:"keySignature,omitempty"`
+}
+
+type jsonCheckpoint struct {
+	Index  int         `json:"index"`
+	Fields []jsonField `json:"fields"`
+}
+
+type jsonField struct {
+	Name  string          `json:"name"`
+	Type  string          `json:"type"`
+	ID    int             `json:"id,omitempty"`
+	Value json.RawMessage `json:"value"`
+}
+
+// MarshalJSON encodes the values in the JSON schema described above. It has a value
+// receiver, so that values held by value in other types are encoded as well.
+func (values AuditableValues) MarshalJSON() ([]byte, error) {
+	v := jsonAuditableValues{
+		History:            make([]jsonHistory, len(values.history)),
+		CheckpointInterval: values.checkpointInterval,
+		ValueCompression:   values.valueCompression,
+	}
+	var err error
+	for i, h := range values.history {
+		v.History[i] = jsonHistory{Signature: h.signature, Hash: h.hash, KeySignature: h.keySignature}
+		if v.History[i].Fields, err = marshalJSONFields(h.fields); err != nil {
+			return nil, err
+		}
+	}
+	if values.latest != nil {
+		if v.Latest, err = marshalJSONFields(values.latest); err != nil {
+			return nil, err
+		}
+	}
+	for _, cp := range values.checkpoints {
+		fields, err := marshalJSONFields(cp.fields)
+		if err != nil {
+			return nil, err
+		}
+		v.Checkpoints = append(v.Checkpoints, jsonCheckpoint{Index: cp.index, Fields: fields})
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON decodes values encoded by MarshalJSON. If none of the entries hold a
+// hash, the hashes are computed, as when deserializing a format without hashes.
+func (values *AuditableValues) UnmarshalJSON(b []byte) error {
+	var v jsonAuditableValues
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	var history []auditHistory
+	if len(v.History) > 0 {
+		history = make([]auditHistory, len(v.History))
+	}
+	hasHashes := false
+	for i, h := range v.History {
+		fields, err := unmarshalJSONFields(h.Fields)
+		if err != nil {
+			return err
+		}
+		history[i] = auditHistory{fields: fields, signature: h.Signature, hash: h.Hash, keySignature: h.KeySignature}
+		hasHashes = hasHashes || h.Hash != nil
+	}
+	var latest fieldSlice
+	if v.Latest != nil {
+		var err error
+		if latest, err = unmarshalJSONFields(v.Latest); err != nil {
+			return err
+		}
+	}
+	var checkpoints []checkpoint
+	for i, cp := range v.Checkpoints {
+		if cp.Index < 0 || cp.Index >= len(history) || (i > 0 && cp.Index <= checkpoints[i-1].index) {
+			return fmt.Errorf("invalid checkpoint index: %d", cp.Index)
+		}
+		fields, err := unmarshalJSONFields(cp.Fields)
+		if err != nil {
+			return err
+		}
+		checkpoints = append(checkpoints, checkpoint{index: cp.Index, fields: fields})
+	}
+
+	values.history = history
+	values.latest = latest
+	values.checkpoints = checkpoints
+	values.checkpointInterval = v.CheckpointInterval
+	values.valueCompression = v.ValueCompression
+	if !hasHashes {
+		return values.computeHashes(0)
+	}
+	return nil
+}
+
+func marshalJSONFields(fields fieldSlice) ([]jsonField, error) {
+	jsonFields := make([]jsonField, len(fields))
+	for i, field := range fields {
+		jsonField, err := marshalJSONValue(field.Value)
+		if err != nil {
+			return nil, fmt.Errorf("cannot encode field %s: %w", field.Name, err)
+		}
+		jsonField.Name = field.Name
+		jsonFields[i] = jsonField
+	}
+	return jsonFields, nil
+}
+
+func unmarshalJSONFields(jsonFields []jsonField) (fieldSlice, error) {
+	fields := make(fieldSlice, len(jsonFields))
+	for i, jsonField := range jsonFields {
+		value, err := unmarshalJSONValue(jsonField)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode field %s: %w", jsonField.Name, err)
+		}
+		fields[i] = Field{jsonField.Name, value}
+	}
+	return fields, nil
+}
+
+// jsonMagicValues holds the JSON names of the magic values.
+var jsonMagicValues = map[magicValue]string{
+	magicValueHistoryCreation:  "created",
+	magicValueFieldRemoved:     "removed",
+	magicValueHistoryTruncated: "truncated",
+}
+
+// marshalJSONValue returns a field holding the encoded value, without the name.
+func marshalJSONValue(value interface{}) (jsonField, error) {
+	field := jsonField{Type: fmt.Sprintf("%T", value)}
+	var v interface{}
+	switch value := value.(type) {
+	case magicValue:
+		name, ok := jsonMagicValues[value]
+		if !ok {
+			return jsonField{}, fmt.Errorf("invalid magic value: %d", value)
+		}
+		field.Type, v = "magic", name
+	case float32:
+		v = jsonFloat[float32]{value}
+	case float64:
+		v = jsonFloat[float64]{value}
+	case []float32:
+		v = toJSONFloats(value)
+	case []float64:
+		v = toJSONFloats(value)
+	case time.Time:
+		v = toJSONTime(value)
+	case []time.Time:
+		times := make([]jsonTime, len(value))
+		for i, t := range value {
+			times[i] = toJSONTime(t)
+		}
+		v = times
+	default:
+		if isBuiltinValue(value) {
+			v = value
+			break
+		}
+		vt, ok := lookupValueType(value)
+		if !ok {
+			return jsonField{}, fmt.Errorf("cannot encode value of type %T", value)
+		}
+		b, err := vt.encode(value)
+		if err != nil {
+			return jsonField{}, err
+		}
+		field.Type, field.ID, v = "registered", vt.id, b
+	}
+	var err error
+	field.Value, err = json.Marshal(v)
+	return field, err
+}
+
+// jsonValueDecoders holds the decoders of the built-in value types by the name of
+// their type, except the magic and registered values.
+var jsonValueDecoders = map[string]func(json.RawMessage) (interface{}, error){
+	"string":      unmarshalJSONAs[string],
+	"bool":        unmarshalJSONAs[bool],
+	"int":         unmarshalJSONAs[int],
+	"int8":        unmarshalJSONAs[int8],
+	"int16":       unmarshalJSONAs[int16],
+	"int32":       unmarshalJSONAs[int32],
+	"int64":       unmarshalJSONAs[int64],
+	"uint":        unmarshalJSONAs[uint],
+	"uint8":       unmarshalJSONAs[uint8],
+	"uint16":      unmarshalJSONAs[uint16],
+	"uint32":      unmarshalJSONAs[uint32],
+	"uint64":      unmarshalJSONAs[uint64],
+	"float32":     unmarshalJSONFloat[float32],
+	"float64":     unmarshalJSONFloat[float64],
+	"[]string":    unmarshalJSONAs[[]string],
+	"[]bool":      unmarshalJSONAs[[]bool],
+	"[]int":       unmarshalJSONAs[[]int],
+	"[]int8":      unmarshalJSONAs[[]int8],
+	"[]int16":     unmarshalJSONAs[[]int16],
+	"[]int32":     unmarshalJSONAs[[]int32],
+	"[]int64":     unmarshalJSONAs[[]int64],
+	"[]uint":      unmarshalJSONAs[[]uint],
+	"[]uint8":     unmarshalJSONAs[[]uint8],
+	"[]uint16":    unmarshalJSONAs[[]uint16],
+	"[]uint32":    unmarshalJSONAs[[]uint32],
+	"[]uint64":    unmarshalJSONAs[[]uint64],
+	"[]float32":   unmarshalJSONFloats[float32],
+	"[]float64":   unmarshalJSONFloats[float64],
+	"time.Time":   unmarshalJSONTime,
+	"[]time.Time": unmarshalJSONTimes,
+}
+
+func unmarshalJSONValue(field jsonField) (interface{}, error) {
+	switch field.Type {
+	case "magic":
+		var name string
+		if err := json.Unmarshal(field.Value, &name); err != nil {
+			return nil, err
+		}
+		for value, valueName := range jsonMagicValues {
+			if valueName == name {
+				return value, nil
+			}
+		}
+		return nil, fmt.Errorf("invalid magic value: %s", name)
+	case "registered":
+		vt, ok := lookupValueTypeByID(field.ID)
+		if !ok {
+			return nil, fmt.Errorf("invalid value type: no type registered with id %d", field.ID)
+		}
+		var b []byte
+		if err := json.Unmarshal(field.Value, &b); err != nil {
+			return nil, err
+		}
+		return vt.decode(b)
+	}
+	decode, ok := jsonValueDecoders[field.Type]
+	if !ok {
+		return nil, fmt.Errorf("invalid value type: %s", field.Type)
+	}
+	return decode(field.Value)
+}
+
+func unmarshalJSONAs[T any](b json.RawMessage) (interface{}, error) {
+	var v T
+	err := json.Unmarshal(b, &v)
+	return v, err
+}
+
+// jsonFloat encodes floats that are NaN or infinite as strings.
+type jsonFloat[T float32 | float64] struct {
+	v T
+}
+
+func (f jsonFloat[T]) MarshalJSON() ([]byte, error) {
+	switch v := float64(f.v); {
+	case math.IsNaN(v):
+		return []byte(`"NaN"`), nil
+	case math.IsInf(v, 1):
+		return []byte(`"+Inf"`), nil
+	case math.IsInf(v, -1):
+		return []byte(`"-Inf"`), nil
+	}
+	return json.Marshal(f.v)
+}
+
+func (f *jsonFloat[T]) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return json.Unmarshal(b, &f.v)
+	}
+	switch s {
+	case "NaN":
+		f.v = T(math.NaN())
+	case "+Inf":
+		f.v = T(math.Inf(1))
+	case "-Inf":
+		f.v = T(math.Inf(-1))
+	default:
+		return fmt.Errorf("invalid float value: %q", s)
+	}
+	return nil
+}
+
+func toJSONFloats[T float32 | float64](values []T) []jsonFloat[T] {
+	if values == nil {
+		return nil
+	}
+	floats := make([]jsonFloat[T], len(values))
+	for i, v := range values {
+		floats[i] = jsonFloat[T]{v}
+	}
+	return floats
+}
+
+func unmarshalJSONFloat[T float32 | float64](b json.RawMessage) (interface{}, error) {
+	var f jsonFloat[T]
+	err := json.Unmarshal(b, &f)
+	return f.v, err
+}
+
+func unmarshalJSONFloats[T float32 | float64](b json.RawMessage) (interface{}, error) {
+	var floats []jsonFloat[T]
+	if err := json.Unmarshal(b, &floats); err != nil {
+		return nil, err
+	}
+	if floats == nil {
+		return []T(nil), nil
+	}
+	values := make([]T, len(floats))
+	for i, f := range floats {
+		values[i] = f.v
+	}
+	return values, nil
+}
+
+// jsonTime is the JSON encoding of a time.Time, preserving its location.
+type jsonTime struct {
+	Time     time.Time `json:"time"`
+	Location string    `json:"location"`
+	Zone     string    `json:"zone"`
+}
+
+func toJSONTime(t time.Time) jsonTime {
+	zone, _ := t.Zone()
+	return jsonTime{Time: t, Location: t.Location().String(), Zone: zone}
+}
+
+func (t jsonTime) time() time.Time {
+	_, offset := t.Time.Zone()
+	return restoreLocation(t.Time, t.Location, t.Zone, offset)
+}
+
+func unmarshalJSONTime(b json.RawMessage) (interface{}, error) {
+	var t jsonTime
+	if err := json.Unmarshal(b, &t); err != nil {
+		return nil, err
+	}
+	return t.time(), nil
+}
+
+func unmarshalJSONTimes(b json.RawMessage) (interface{}, error) {
+	var times []jsonTime
+	if err := json.Unmarshal(b, &times); err != nil {
+		return nil, err
+	}
+	if times == nil {
+		return []time.Time(nil), nil
+	}
+	values := make([]time.Time, len(times))
+	for i, t := range times {
+		values[i] = t.time()
+	}
+	return values, nil
+}
+
+type jsonSignature struct {
+	Auditor    Auditor           `json:"auditor"`
+	Timestamp  time.Time         `json:"timestamp"`
+	OnBehalfOf *Auditor          `json:"onBehalfOf,omitempty"`
+	Reason     string            `json:"reason,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// MarshalJSON encodes the signature as {"auditor": <Auditor>, "timestamp": "<RFC 3339>"},
+// along with "onBehalfOf", "reason" and "attributes" if set.
+func (sig Signature) MarshalJSON() ([]byte, error) {
+	v := jsonSignature{
+		Auditor:    sig.auditor,
+		Timestamp:  sig.timestamp,
+		Reason:     sig.Reason(),
+		Attributes: sig.Attributes(),
+	}
+	if onBehalfOf := sig.OnBehalfOf(); !onBehalfOf.IsZero() {
+		v.OnBehalfOf = &onBehalfOf
+	}
+	return json.Marshal(v)
+}
+
+func (sig *Signature) UnmarshalJSON(b []byte) error {
+	var v jsonSignature
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	opts := []SignatureOption{WithReason(v.Reason), WithAttributes(v.Attributes)}
+	if v.OnBehalfOf != nil {
+		opts = append(opts, WithOnBehalfOf(*v.OnBehalfOf))
+	}
+	*sig = NewSignature(v.Auditor, v.Timestamp, opts...)
+	return nil
+}
+
+type jsonAuditor struct {
+	Kind AuditorKind `json:"kind"`
+	ID   string      `json:"id"`
+}
+
+// MarshalJSON encodes the auditor as {"kind": "<kind>", "id": "<id>"}.
+func (auditor Auditor) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonAuditor{Kind: auditor.kind, ID: auditor.id})
+}
+
+func (auditor *Auditor) UnmarshalJSON(b []byte) error {
+	var v jsonAuditor
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	decoded, err := MakeAuditor(v.Kind, v.ID)
+	if err != nil {
+		return err
+	}
+	*auditor = decoded
+	return nil
+}
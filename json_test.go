@@ -0,0 +1,141 @@
+package audit
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditableValuesJSON(t *testing.T) {
+	getSig := new(signatureGenerator).Next
+
+	oslo, err := time.LoadLocation("Europe/Oslo")
+	if err != nil {
+		t.Skipf("Time zone database not available: %v", err)
+	}
+	obj := &auditableObject{Values: map[string]interface{}{
+		"string":  "abc",
+		"int64":   int64(1<<62 + 1),
+		"uint64":  uint64(1<<64 - 1),
+		"float32": float32(1.5),
+		"inf":     math.Inf(-1),
+		"bytes":   []byte{1, 2, 3},
+		"floats":  []float64{math.Inf(1), -0.5},
+		"time":    time.Date(2020, 6, 1, 12, 0, 0, 123, oslo),
+		"times":   []time.Time{time.Date(2020, 1, 1, 0, 0, 0, 0, time.FixedZone("X", 3600))},
+		"money":   testMoney{"NOK", 100},
+		"nested":  []Field{{"a", 1}},
+	}}
+	var av AuditableValues
+	av.SetCheckpointInterval(2)
+	if _, err := av.Audit(nil, obj, getSig()); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		cpy := obj.Copy()
+		obj.Values["int64"] = int64(i)
+		delete(obj.Values, "string")
+		obj.Values["added"] = true
+		if _, err := av.Audit(cpy, obj, getSig()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := av.SignLatest(key); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := json.Marshal(&av)
+	if err != nil {
+		t.Fatalf("MarshalJSON() error: %v", err)
+	}
+	for _, want := range []string{
+		`{"name":"","type":"magic","value":"created"}`,
+		`{"name":"added","type":"magic","value":"removed"}`,
+		`{"name":"int64","type":"int64","value":4611686018427387905}`,
+		`{"name":"inf","type":"float64","value":"-Inf"}`,
+		`{"name":"money","type":"registered","id":1000,"value":`,
+		`"location":"Europe/Oslo","zone":"CEST"`,
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("MarshalJSON() output does not contain %s\n%s", want, b)
+		}
+	}
+
+	var got AuditableValues
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("UnmarshalJSON() error: %v", err)
+	}
+	if !reflect.DeepEqual(av, got) {
+		t.Errorf("Wrong value after JSON round trip\nWant %v\nGot  %v", av, got)
+	}
+
+	// Converting between the binary and JSON formats is lossless
+	var fromBinary AuditableValues
+	if b, err := av.Serialize(); err != nil {
+		t.Fatalf("Serialize() error: %v", err)
+	} else if err := fromBinary.Deserialize(b); err != nil {
+		t.Fatalf("Deserialize() error: %v", err)
+	}
+	if b2, err := json.Marshal(&fromBinary); err != nil {
+		t.Fatalf("MarshalJSON() error: %v", err)
+	} else if string(b) != string(b2) {
+		t.Errorf("Wrong JSON after binary round trip\nWant %s\nGot  %s", b, b2)
+	}
+	if err := got.Verify(); err != nil {
+		t.Errorf("Verify() error after JSON round trip: %v", err)
+	}
+
+	// Values held by value in other types are encoded the same way
+	type holder struct {
+		V AuditableValues
+	}
+	if b2, err := json.Marshal(holder{V: av}); err != nil {
+		t.Fatalf("MarshalJSON() error: %v", err)
+	} else if want := `{"V":` + string(b) + `}`; string(b2) != want {
+		t.Errorf("Wrong JSON of values held by value\nWant %s\nGot  %s", want, b2)
+	}
+}
+
+func TestJSONValuesNaN(t *testing.T) {
+	for _, value := range []interface{}{math.NaN(), float32(math.NaN()), []float32{float32(math.NaN())}} {
+		field, err := marshalJSONValue(value)
+		if err != nil {
+			t.Fatalf("marshalJSONValue(%v) error: %v", value, err)
+		}
+		got, err := unmarshalJSONValue(field)
+		if err != nil {
+			t.Fatalf("unmarshalJSONValue(%s) error: %v", field.Value, err)
+		}
+		if reflect.TypeOf(got) != reflect.TypeOf(value) || !strings.Contains(fmt.Sprint(got), "NaN") {
+			t.Errorf("Wrong value after JSON round trip of %v: %#v", value, got)
+		}
+	}
+}
+
+func TestSignatureJSON(t *testing.T) {
+	sig := NewSignature(NewAuditor("user", "alice"), time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC))
+	b, err := json.Marshal(sig)
+	if err != nil {
+		t.Fatalf("MarshalJSON() error: %v", err)
+	}
+	want := `{"auditor":{"kind":"user","id":"alice"},"timestamp":"2020-01-02T03:04:05.000000006Z"}`
+	if string(b) != want {
+		t.Errorf("Wrong JSON\nWant %s\nGot  %s", want, b)
+	}
+	var got Signature
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("UnmarshalJSON() error: %v", err)
+	}
+	if got != sig {
+		t.Errorf("Wrong signature after JSON round trip\nWant %v\nGot  %v", sig, got)
+	}
+}
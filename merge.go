@@ -0,0 +1,252 @@
+package audit
+
+import (
+	"fmt"
+	"sort"
+)
+
+// MergeConflict describes a field that was changed to different values by both
+// histories passed to Merge. Base holds the value of the field as of the common
+// history, while A and B hold the value after all changes of each history, signed
+// by the latest audit that changed the field.
+type MergeConflict struct {
+	Name string
+	Base FieldVersion
+	A, B FieldVersion
+}
+
+func (conflict MergeConflict) String() string {
+	format := func(version FieldVersion) string {
+		if !version.Present {
+			return "<not present>"
+		}
+		return fmt.Sprint(version.Value)
+	}
+	return fmt.Sprintf("%s: %s -> %s (%s) / %s (%s)", conflict.Name, format(conflict.Base),
+		format(conflict.A), conflict.A.Signature, format(conflict.B), conflict.B.Signature)
+}
+
+// MergeChoice is the resolution of a merge conflict.
+type MergeChoice int
+
+const (
+	// ChooseA keeps the changes to the field made by history a, and drops those of b.
+	ChooseA MergeChoice = iota + 1
+	// ChooseB keeps the changes to the field made by history b, and drops those of a.
+	ChooseB
+)
+
+// MergePolicy resolves a conflict found by Merge. Returning an error aborts the merge.
+type MergePolicy func(conflict MergeConflict) (MergeChoice, error)
+
+// MergeConflictError is the error returned by FailOnConflict.
+type MergeConflictError struct {
+	Conflict MergeConflict
+}
+
+func (err *MergeConflictError) Error() string {
+	return fmt.Sprintf("merge conflict in field %s", err.Conflict)
+}
+
+// FailOnConflict is a MergePolicy that aborts the merge with a *MergeConflictError.
+func FailOnConflict(conflict MergeConflict) (MergeChoice, error) {
+	return 0, &MergeConflictError{Conflict: conflict}
+}
+
+// PreferA is a MergePolicy that resolves all conflicts in favor of history a.
+func PreferA(MergeConflict) (MergeChoice, error) {
+	return ChooseA, nil
+}
+
+// PreferB is a MergePolicy that resolves all conflicts in favor of history b.
+func PreferB(MergeConflict) (MergeChoice, error) {
+	return ChooseB, nil
+}
+
+// PreferLatest is a MergePolicy that resolves conflicts in favor of the history that
+// changed the field last, and in favor of a if both changed it at the same time.
+func PreferLatest(conflict MergeConflict) (MergeChoice, error) {
+	if conflict.B.Signature.timestamp.After(conflict.A.Signature.timestamp) {
+		return ChooseB, nil
+	}
+	return ChooseA, nil
+}
+
+// Merge combines the histories a and b, which both extend the common history base,
+// into a single history. The entries of a and b after base are replayed on top of
+// base in timestamp order. Since the timestamps of a history must be increasing, an
+// error is returned if a and b have entries with equal timestamps that are both
+// kept in the merged history.
+//
+// Fields changed to different values by both a and b are conflicts, which are passed
+// to policy in order of their names. The changes of the losing history to the field
+// are dropped, as are entries that only changed fields that were lost. If policy is
+// nil, FailOnConflict is used.
+//
+// Both a and b must hold a snapshot of their latest state, which they do unless they
+// were deserialized from a format without it and have not been audited since. The
+// merged history keeps the checkpoint interval and value compression of base.
+// Since the entries after base get new hashes (see Verify), their signatures made
+// by SignLatest are dropped.
+func Merge(base, a, b *AuditableValues, policy MergePolicy) (*AuditableValues, error) {
+	if policy == nil {
+		policy = FailOnConflict
+	}
+	if base.IsZero() {
+		return nil, fmt.Errorf("cannot merge: base history is empty")
+	}
+	n := len(base.history)
+	sides := []*AuditableValues{a, b}
+	sideNames := []string{"a", "b"}
+	changeSets := make([][]ChangeSet, len(sides))
+	var baseState fieldSlice
+	for i, side := range sides {
+		if !side.extends(base) {
+			return nil, fmt.Errorf("cannot merge: %s does not extend base", sideNames[i])
+		}
+		if side.latest == nil {
+			return nil, fmt.Errorf("cannot merge: %s has no snapshot of the latest state", sideNames[i])
+		}
+		sets, state, err := side.changeSetsFrom(side.latest.copy(), n)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			baseState = state
+		} else if !equalFieldSlices(baseState, state) {
+			return nil, fmt.Errorf("cannot merge: a and b do not agree on the state of base")
+		}
+		changeSets[i] = sets
+	}
+
+	// Find the conflicts and let the policy choose which history to keep changes from
+	winners := make(map[string]int)
+	versionsA, versionsB := latestVersions(changeSets[0]), latestVersions(changeSets[1])
+	names := make([]string, 0, len(versionsA))
+	for name, versionA := range versionsA {
+		versionB, ok := versionsB[name]
+		if !ok || (versionA.Present == versionB.Present && (!versionA.Present || equals(versionA.Value, versionB.Value))) {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		field, present := baseState.TryGet(name)
+		conflict := MergeConflict{
+			Name: name,
+			Base: FieldVersion{Signature: base.LatestSignatureForField(name), Value: field.Value, Present: present},
+			A:    versionsA[name],
+			B:    versionsB[name],
+		}
+		choice, err := policy(conflict)
+		if err != nil {
+			return nil, err
+		}
+		switch choice {
+		case ChooseA:
+			winners[name] = 0
+		case ChooseB:
+			winners[name] = 1
+		default:
+			return nil, fmt.Errorf("invalid merge choice for field %s: %d", name, choice)
+		}
+	}
+
+	merged := &AuditableValues{checkpointInterval: base.checkpointInterval, valueCompression: base.valueCompression}
+	merged.history = append(merged.history, base.history...)
+	merged.checkpoints = append(merged.checkpoints, base.checkpoints...)
+	state := baseState.copy()
+	for i, j := 0, 0; i < len(changeSets[0]) || j < len(changeSets[1]); {
+		side := 0
+		if i == len(changeSets[0]) || (j < len(changeSets[1]) &&
+			changeSets[1][j].Signature.timestamp.Before(changeSets[0][i].Signature.timestamp)) {
+			side = 1
+		}
+		var changeSet ChangeSet
+		if side == 0 {
+			changeSet, i = changeSets[0][i], i+1
+		} else {
+			changeSet, j = changeSets[1][j], j+1
+		}
+
+		var fields fieldSlice
+		for _, change := range changeSet.Changes {
+			if winner, ok := winners[change.Name]; ok && winner != side {
+				continue
+			}
+			old, hasOld := state.TryGet(change.Name)
+			if change.Kind == FieldRemoved {
+				if hasOld {
+					fields = append(fields, old)
+					state.Remove(change.Name)
+				}
+				continue
+			}
+			if !hasOld {
+				fields = append(fields, Field{change.Name, magicValueFieldRemoved})
+			} else if !equals(old.Value, change.New) {
+				fields = append(fields, old)
+			} else {
+				continue
+			}
+			state.Set(change.Name, change.New)
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		if latest := merged.LatestSignature(); !changeSet.Signature.timestamp.After(latest.timestamp) {
+			return nil, fmt.Errorf("cannot merge: a and b have entries with equal timestamps (%s and %s)",
+				latest, changeSet.Signature)
+		}
+		if err := merged.addHistory(changeSet.Signature, fields...); err != nil {
+			return nil, err
+		}
+		merged.addCheckpoint(state)
+	}
+	merged.latest = state
+	return merged, nil
+}
+
+// extends returns whether the history starts with all entries of base.
+func (values *AuditableValues) extends(base *AuditableValues) bool {
+	if len(values.history) < len(base.history) {
+		return false
+	}
+	for i, h := range base.history {
+		other := values.history[i]
+		if !h.signature.Equal(other.signature) || !equalFieldSlices(h.fields, other.fields) {
+			return false
+		}
+	}
+	return true
+}
+
+// latestVersions returns the latest version of each field changed by the change sets.
+func latestVersions(changeSets []ChangeSet) map[string]FieldVersion {
+	versions := make(map[string]FieldVersion)
+	for _, changeSet := range changeSets {
+		for _, change := range changeSet.Changes {
+			versions[change.Name] = FieldVersion{
+				Signature: changeSet.Signature,
+				Value:     change.New,
+				Present:   change.Kind != FieldRemoved,
+			}
+		}
+	}
+	return versions
+}
+
+// equalFieldSlices returns whether the slices hold the same fields, in any order.
+func equalFieldSlices(s1, s2 fieldSlice) bool {
+	if len(s1) != len(s2) {
+		return false
+	}
+	for _, field := range s1 {
+		other, ok := s2.TryGet(field.Name)
+		if !ok || !equals(field.Value, other.Value) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,187 @@
+package audit
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	getSig := new(signatureGenerator).Next
+
+	// The common history creates the object
+	obj := &auditableObject{Values: map[string]interface{}{"title": "t0", "status": "draft", "note": "n0"}}
+	var base AuditableValues
+	if _, err := base.Audit(nil, obj, getSig()); err != nil {
+		t.Fatal(err)
+	}
+	objA, objB := obj.Copy(), obj.Copy()
+	a, b := base, base
+	a.history = append([]auditHistory(nil), base.history...)
+	b.history = append([]auditHistory(nil), base.history...)
+	audit := func(av *AuditableValues, obj *auditableObject, values map[string]interface{}) Signature {
+		cpy := obj.Copy()
+		for name, value := range values {
+			if value == nil {
+				delete(obj.Values, name)
+			} else {
+				obj.Values[name] = value
+			}
+		}
+		sig := getSig()
+		if _, err := av.Audit(cpy, obj, sig); err != nil {
+			t.Fatal(err)
+		}
+		return sig
+	}
+
+	// The copies are edited in interleaved order. Both change the status, and they
+	// remove and change the note to the same values, which is not a conflict.
+	sigA1 := audit(&a, objA, map[string]interface{}{"title": "a1", "status": "published"})
+	sigB1 := audit(&b, objB, map[string]interface{}{"status": "archived", "tags": []string{"b"}})
+	sigA2 := audit(&a, objA, map[string]interface{}{"note": nil})
+	audit(&b, objB, map[string]interface{}{"note": nil})
+
+	var conflicts []MergeConflict
+	recordingPolicy := func(choice MergeChoice) MergePolicy {
+		return func(conflict MergeConflict) (MergeChoice, error) {
+			conflicts = append(conflicts, conflict)
+			return choice, nil
+		}
+	}
+	merged, err := Merge(&base, &a, &b, recordingPolicy(ChooseB))
+	if err != nil {
+		t.Fatalf("Merge() error: %v", err)
+	}
+	wantConflicts := []MergeConflict{{
+		Name: "status",
+		Base: FieldVersion{Signature: base.CreationSignature(), Value: "draft", Present: true},
+		A:    FieldVersion{Signature: sigA1, Value: "published", Present: true},
+		B:    FieldVersion{Signature: sigB1, Value: "archived", Present: true},
+	}}
+	if !reflect.DeepEqual(conflicts, wantConflicts) {
+		t.Errorf("Wrong conflicts passed to policy\nWant %v\nGot  %v", wantConflicts, conflicts)
+	}
+
+	// The second removal of the note changes nothing, and is dropped
+	if got, want := merged.Signatures(), (SignatureSlice{base.CreationSignature(), sigA1, sigB1, sigA2}); !reflect.DeepEqual(got, want) {
+		t.Errorf("Wrong signatures in merged history\nWant %v\nGot  %v", want, got)
+	}
+	current := &auditableObject{Values: map[string]interface{}{"title": "a1", "status": "archived", "tags": []string{"b"}}}
+	if err := merged.Restore(obj); err != nil {
+		t.Fatalf("Restore() error: %v", err)
+	}
+	if !reflect.DeepEqual(current, obj) {
+		t.Errorf("Wrong merged state\nWant %v\nGot  %v", current, obj)
+	}
+	if err := merged.Verify(); err != nil {
+		t.Errorf("Verify() error on merged history: %v", err)
+	}
+
+	// The status change of a is dropped, so rolling back to it keeps the base status
+	if err := merged.RollbackTo(obj, sigA1.Timestamp()); err != nil {
+		t.Fatal(err)
+	}
+	want := &auditableObject{
+		Values:    map[string]interface{}{"title": "a1", "status": "draft", "note": "n0"},
+		tRollback: sigA1.Timestamp(),
+	}
+	if !reflect.DeepEqual(want, obj) {
+		t.Errorf("Wrong state after rolling back merged history\nWant %v\nGot  %v", want, obj)
+	}
+
+	// Merged histories can be audited further
+	if _, err := merged.Audit(current.Copy(), &auditableObject{Values: map[string]interface{}{}}, getSig()); err != nil {
+		t.Errorf("Audit() error on merged history: %v", err)
+	}
+
+	// Choosing a keeps the status of a
+	conflicts = nil
+	if merged, err = Merge(&base, &a, &b, PreferA); err != nil {
+		t.Fatalf("Merge() error: %v", err)
+	}
+	if err := merged.Restore(obj); err != nil {
+		t.Fatalf("Restore() error: %v", err)
+	}
+	if got := obj.Values["status"]; got != "published" {
+		t.Errorf("Wrong status after merge preferring a: %v", got)
+	}
+
+	// Conflicts fail the merge by default
+	wantErr := &MergeConflictError{Conflict: wantConflicts[0]}
+	if _, err := Merge(&base, &a, &b, nil); !reflect.DeepEqual(err, wantErr) {
+		t.Errorf("Wrong error from Merge() with conflicts\nWant %v\nGot  %v", wantErr, err)
+	}
+
+	// Histories must extend base
+	var other AuditableValues
+	if _, err := other.Audit(nil, obj, getSig()); err != nil {
+		t.Fatal(err)
+	}
+	wantErr2 := fmt.Errorf("cannot merge: b does not extend base")
+	if _, err := Merge(&base, &a, &other, PreferA); !gotError(wantErr2, err) {
+		t.Errorf("Wrong error from Merge() with unrelated history\nWant %v\nGot  %v", wantErr2, err)
+	}
+}
+
+func TestPreferLatest(t *testing.T) {
+	getSig := new(signatureGenerator).Next
+	sig1, sig2 := getSig(), getSig()
+	tests := []struct {
+		a, b Signature
+		want MergeChoice
+	}{
+		{sig1, sig2, ChooseB},
+		{sig2, sig1, ChooseA},
+		{sig1, sig1, ChooseA},
+	}
+	for _, test := range tests {
+		conflict := MergeConflict{A: FieldVersion{Signature: test.a}, B: FieldVersion{Signature: test.b}}
+		if got, err := PreferLatest(conflict); err != nil || got != test.want {
+			t.Errorf("PreferLatest(%s, %s) = %v, %v, want %v", test.a, test.b, got, err, test.want)
+		}
+	}
+}
+
+func TestMergeEqualTimestamps(t *testing.T) {
+	getSig := new(signatureGenerator).Next
+
+	obj := &auditableObject{Values: map[string]interface{}{"title": "t0", "status": "draft"}}
+	var base AuditableValues
+	if _, err := base.Audit(nil, obj, getSig()); err != nil {
+		t.Fatal(err)
+	}
+	ts := getSig().Timestamp()
+	sigA, sigB := NewSignature(NewAuditor("user", "a"), ts), NewSignature(NewAuditor("user", "b"), ts)
+	audit := func(sig Signature, values map[string]interface{}) *AuditableValues {
+		av := base
+		av.history = append([]auditHistory(nil), base.history...)
+		cpy, updated := obj.Copy(), obj.Copy()
+		for name, value := range values {
+			updated.Values[name] = value
+		}
+		if _, err := av.Audit(cpy, updated, sig); err != nil {
+			t.Fatal(err)
+		}
+		return &av
+	}
+
+	// Entries of a and b at the same time cannot both be kept, since the timestamps
+	// of the merged history would not be increasing
+	a := audit(sigA, map[string]interface{}{"title": "a"})
+	b := audit(sigB, map[string]interface{}{"status": "published"})
+	wantErr := fmt.Errorf("cannot merge: a and b have entries with equal timestamps (%s and %s)", sigA, sigB)
+	if _, err := Merge(&base, a, b, PreferA); !gotError(wantErr, err) {
+		t.Errorf("Wrong error from Merge() with equal timestamps\nWant %v\nGot  %v", wantErr, err)
+	}
+
+	// If the entry of one of them is dropped, the timestamps are increasing
+	b = audit(sigB, map[string]interface{}{"title": "b"})
+	merged, err := Merge(&base, a, b, PreferA)
+	if err != nil {
+		t.Fatalf("Merge() error with the entry of b dropped: %v", err)
+	}
+	if got, want := merged.Signatures(), (SignatureSlice{base.CreationSignature(), sigA}); !reflect.DeepEqual(got, want) {
+		t.Errorf("Wrong signatures in merged history\nWant %v\nGot  %v", want, got)
+	}
+}
@@ -0,0 +1,111 @@
+package audit
+
+import (
+	"sort"
+	"time"
+)
+
+// HistoryQuery selects history entries. Each criterion that is set must match for
+// an entry to be selected, while criteria that are not set match all entries.
+type HistoryQuery struct {
+	// Auditors selects the entries audited by any of the auditors. For entries
+	// audited on behalf of another auditor, this is the auditor that made the
+	// change. See WithOnBehalfOf.
+	Auditors []Auditor
+	// OnBehalfOf selects the entries audited on behalf of any of the auditors.
+	OnBehalfOf []Auditor
+	// Delegated selects the entries audited on behalf of another auditor, if true.
+	Delegated bool
+	// AuditorKinds selects the entries audited by an auditor of any of the kinds.
+	AuditorKinds []AuditorKind
+	// From selects the entries audited at or after From.
+	From time.Time
+	// To selects the entries audited before To.
+	To time.Time
+	// Fields selects the entries that changed any of the fields. Fields match
+	// nested fields by their path prefix, so that "address" matches changes of
+	// "address.city". The creation entry never matches, since the history does not
+	// record which fields the object was created with.
+	Fields []string
+}
+
+// HistoryMatch is a history entry selected by Query.
+type HistoryMatch struct {
+	Signature Signature
+	// Fields holds the names of all fields changed by the entry, ordered by name. It
+	// is empty for the creation entry.
+	Fields []string
+}
+
+// Query returns the history entries selected by q, ordered ascending by timestamp.
+func (values *AuditableValues) Query(q HistoryQuery) []HistoryMatch {
+	var matches []HistoryMatch
+	for _, h := range values.history {
+		if !q.matches(h) {
+			continue
+		}
+		match := HistoryMatch{Signature: h.signature}
+		for _, field := range h.fields {
+			if field.Value != magicValueHistoryCreation && field.Value != magicValueHistoryTruncated {
+				match.Fields = append(match.Fields, field.Name)
+			}
+		}
+		sort.Strings(match.Fields)
+		matches = append(matches, match)
+	}
+	return matches
+}
+
+func (q HistoryQuery) matches(h auditHistory) bool {
+	sig := h.signature
+	if len(q.Auditors) > 0 {
+		found := false
+		for _, auditor := range q.Auditors {
+			found = found || auditor.Equal(sig.auditor)
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(q.OnBehalfOf) > 0 {
+		found := false
+		for _, auditor := range q.OnBehalfOf {
+			found = found || auditor.Equal(sig.OnBehalfOf())
+		}
+		if !found {
+			return false
+		}
+	}
+	if q.Delegated && sig.OnBehalfOf().IsZero() {
+		return false
+	}
+	if len(q.AuditorKinds) > 0 {
+		found := false
+		for _, kind := range q.AuditorKinds {
+			found = found || kind == sig.auditor.kind
+		}
+		if !found {
+			return false
+		}
+	}
+	if !q.From.IsZero() && sig.timestamp.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !sig.timestamp.Before(q.To) {
+		return false
+	}
+	if len(q.Fields) > 0 {
+		for _, field := range h.fields {
+			if field.Value == magicValueHistoryCreation || field.Value == magicValueHistoryTruncated {
+				continue
+			}
+			for _, name := range q.Fields {
+				if fieldPathHasPrefix(field.Name, name) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,90 @@
+package audit
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAuditableValuesQuery(t *testing.T) {
+	var (
+		alice   = NewAuditor("user", "alice")
+		bob     = NewAuditor("user", "bob")
+		service = NewAuditor("service", "sync")
+		t0      = time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+		sig0    = NewSignature(alice, t0)
+		sig1    = NewSignature(service, t0.Add(24*time.Hour))
+		sig2    = NewSignature(bob, t0.Add(48*time.Hour), WithOnBehalfOf(alice))
+		sig3    = NewSignature(service, t0.Add(72*time.Hour))
+	)
+	var av AuditableValues
+	av.addHistory(sig0, Field{Value: magicValueHistoryCreation})
+	av.addHistory(sig1, Field{Name: "status", Value: "draft"}, Field{Name: "address.city", Value: "Oslo"})
+	av.addHistory(sig2, Field{Name: "title", Value: magicValueFieldRemoved})
+	av.addHistory(sig3, Field{Name: "status", Value: "published"})
+
+	tests := []struct {
+		name  string
+		query HistoryQuery
+		want  []HistoryMatch
+	}{
+		{
+			name:  "All",
+			query: HistoryQuery{},
+			want: []HistoryMatch{
+				{Signature: sig0},
+				{Signature: sig1, Fields: []string{"address.city", "status"}},
+				{Signature: sig2, Fields: []string{"title"}},
+				{Signature: sig3, Fields: []string{"status"}},
+			},
+		},
+		{
+			name:  "Auditor",
+			query: HistoryQuery{Auditors: []Auditor{alice, bob}},
+			want:  []HistoryMatch{{Signature: sig0}, {Signature: sig2, Fields: []string{"title"}}},
+		},
+		{
+			name:  "On behalf of",
+			query: HistoryQuery{OnBehalfOf: []Auditor{alice}},
+			want:  []HistoryMatch{{Signature: sig2, Fields: []string{"title"}}},
+		},
+		{
+			name:  "Delegated",
+			query: HistoryQuery{Delegated: true, From: sig1.Timestamp()},
+			want:  []HistoryMatch{{Signature: sig2, Fields: []string{"title"}}},
+		},
+		{
+			name:  "Delegated by other auditor",
+			query: HistoryQuery{Delegated: true, Auditors: []Auditor{service}},
+			want:  nil,
+		},
+		{
+			name:  "Auditor kind and time window",
+			query: HistoryQuery{AuditorKinds: []AuditorKind{"service"}, From: sig1.Timestamp(), To: sig3.Timestamp()},
+			want:  []HistoryMatch{{Signature: sig1, Fields: []string{"address.city", "status"}}},
+		},
+		{
+			name:  "Nested field",
+			query: HistoryQuery{Fields: []string{"address"}},
+			want:  []HistoryMatch{{Signature: sig1, Fields: []string{"address.city", "status"}}},
+		},
+		{
+			name:  "Fields and auditor kind",
+			query: HistoryQuery{Fields: []string{"status", "title"}, AuditorKinds: []AuditorKind{"service"}},
+			want: []HistoryMatch{
+				{Signature: sig1, Fields: []string{"address.city", "status"}},
+				{Signature: sig3, Fields: []string{"status"}},
+			},
+		},
+		{
+			name:  "No match",
+			query: HistoryQuery{Auditors: []Auditor{bob}, Fields: []string{"status"}},
+			want:  nil,
+		},
+	}
+	for _, test := range tests {
+		if got := av.Query(test.query); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Query(%s) returned wrong matches\nWant %v\nGot  %v", test.name, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+// Package render renders the history of audited objects as human-readable change
+// reports in plain text, Markdown or HTML.
+//
+// Each change is rendered on its own line, prefixed by the date and auditor of the
+// audit that made it, such as:
+//
+//	2024-05-01 user/alice changed status: draft → published
+//
+// Changes made on behalf of another auditor name both, as in "support/bob (on behalf
+// of user/alice)". See audit.WithOnBehalfOf.
+//
+// Auditors are rendered by their kind and id, or by their display names if an
+// audit.AuditorResolver is given with WithAuditorResolver.
+//
+// The first change set of a compacted history holds the state as of the baseline
+// (see audit.AuditableValues.Compact) rather than changes made by an auditor, and
+// is rendered without an auditor, as in:
+//
+//	2024-05-01 baseline status: draft
+//
+// The values after each audit are computed from the current state of the object,
+// as done by audit.AuditableValues.Changes.
+package render
+
+import (
+	"fmt"
+	"github.com/snechholt/audit"
+	"html"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Option customizes the rendering.
+type Option func(*options)
+
+type options struct {
+	location     *time.Location
+	dateLayout   string
+	timeLayout   string
+	displayNames map[string]string
+	resolver     audit.AuditorResolver
+}
+
+// WithLocation renders timestamps and time values in loc rather than in UTC.
+func WithLocation(loc *time.Location) Option {
+	return func(o *options) { o.location = loc }
+}
+
+// WithDateLayout sets the layout of the timestamps of the audits, which is
+// "2006-01-02" by default.
+func WithDateLayout(layout string) Option {
+	return func(o *options) { o.dateLayout = layout }
+}
+
+// WithTimeLayout sets the layout of time values, which is "2006-01-02 15:04:05 MST"
+// by default.
+func WithTimeLayout(layout string) Option {
+	return func(o *options) { o.timeLayout = layout }
+}
+
+// WithDisplayNames renders fields with the display names in names, which are keyed
+// by the field names. Nested fields are keyed by their full path, and fields
+// without a display name are rendered with their field name.
+func WithDisplayNames(names map[string]string) Option {
+	return func(o *options) { o.displayNames = names }
+}
+
+// WithAuditorResolver renders auditors with their display names as resolved by
+// resolver. See audit.FormatAuditor.
+func WithAuditorResolver(resolver audit.AuditorResolver) Option {
+	return func(o *options) { o.resolver = resolver }
+}
+
+func getOptions(opts []Option) *options {
+	o := &options{
+		location:   time.UTC,
+		dateLayout: "2006-01-02",
+		timeLayout: "2006-01-02 15:04:05 MST",
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// Text writes the change report of the history of current to w as plain text.
+func Text(w io.Writer, values *audit.AuditableValues, current audit.AuditableObject, opts ...Option) error {
+	return render(w, values, current, textFormat{}, opts)
+}
+
+// Markdown writes the change report of the history of current to w as a Markdown
+// list. Values are escaped so that they are rendered literally.
+func Markdown(w io.Writer, values *audit.AuditableValues, current audit.AuditableObject, opts ...Option) error {
+	return render(w, values, current, markdownFormat{}, opts)
+}
+
+// HTML writes the change report of the history of current to w as an HTML list.
+// All names and values are escaped.
+func HTML(w io.Writer, values *audit.AuditableValues, current audit.AuditableObject, opts ...Option) error {
+	return render(w, values, current, htmlFormat{}, opts)
+}
+
+// line holds the parts of a rendered change, each escaped for the format. auditor
+// is empty for the baseline of a compacted history.
+type line struct {
+	timestamp time.Time
+	date      string
+	auditor   string
+	verb      string
+	field     string
+	old, new  string
+	kind      audit.ChangeKind
+}
+
+type format interface {
+	begin(w io.Writer) error
+	line(w io.Writer, l line) error
+	end(w io.Writer) error
+	escape(s string) string
+}
+
+func render(w io.Writer, values *audit.AuditableValues, current audit.AuditableObject, f format, opts []Option) error {
+	o := getOptions(opts)
+	changeSets, err := values.Changes(current)
+	if err != nil {
+		return err
+	}
+	if err := f.begin(w); err != nil {
+		return err
+	}
+	for i, changeSet := range changeSets {
+		changes := append([]audit.Change(nil), changeSet.Changes...)
+		sort.Slice(changes, func(i, j int) bool { return changes[i].Name < changes[j].Name })
+		timestamp := changeSet.Signature.Timestamp().In(o.location)
+		for _, change := range changes {
+			l := line{
+				timestamp: timestamp,
+				date:      f.escape(timestamp.Format(o.dateLayout)),
+				field:     f.escape(o.displayName(change.Name)),
+				old:       f.escape(o.formatValue(change.Old)),
+				new:       f.escape(o.formatValue(change.New)),
+				kind:      change.Kind,
+			}
+			if !changeSet.Baseline {
+				l.auditor = f.escape(o.auditorLabel(changeSet.Signature))
+			}
+			switch {
+			case changeSet.Baseline:
+				l.verb = "baseline"
+			case i == 0:
+				l.verb = "created"
+			case change.Kind == audit.FieldAdded:
+				l.verb = "added"
+			case change.Kind == audit.FieldRemoved:
+				l.verb = "removed"
+			default:
+				l.verb = "changed"
+			}
+			if err := f.line(w, l); err != nil {
+				return err
+			}
+		}
+	}
+	return f.end(w)
+}
+
+func (o *options) displayName(name string) string {
+	if displayName, ok := o.displayNames[name]; ok {
+		return displayName
+	}
+	return name
+}
+
+// auditorLabel returns the auditor of the signature, along with the auditor acted on
+// behalf of, if any.
+func (o *options) auditorLabel(sig audit.Signature) string {
+	auditor := audit.FormatAuditor(sig.Auditor(), o.resolver)
+	if onBehalfOf := sig.OnBehalfOf(); !onBehalfOf.IsZero() {
+		return fmt.Sprintf("%s (on behalf of %s)", auditor, audit.FormatAuditor(onBehalfOf, o.resolver))
+	}
+	return auditor
+}
+
+func (o *options) formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case time.Time:
+		return v.In(o.location).Format(o.timeLayout)
+	case []byte:
+		return fmt.Sprintf("%x", v)
+	}
+	if rv := reflect.ValueOf(value); rv.Kind() == reflect.Slice {
+		elems := make([]string, rv.Len())
+		for i := range elems {
+			elems[i] = o.formatValue(rv.Index(i).Interface())
+		}
+		return "[" + strings.Join(elems, ", ") + "]"
+	}
+	return fmt.Sprint(value)
+}
+
+// describe returns the text of the change following the date, starting with the
+// auditor, if any.
+func (l line) describe(field func(string) string) string {
+	var auditor string
+	if l.auditor != "" {
+		auditor = l.auditor + " "
+	}
+	return auditor + l.describeChange(field)
+}
+
+// describeChange returns the text of the change following the auditor.
+func (l line) describeChange(field func(string) string) string {
+	switch {
+	case l.verb == "created" || l.verb == "baseline" || l.kind == audit.FieldAdded:
+		return fmt.Sprintf("%s %s: %s", l.verb, field(l.field), l.new)
+	case l.kind == audit.FieldRemoved:
+		return fmt.Sprintf("%s %s (was: %s)", l.verb, field(l.field), l.old)
+	default:
+		return fmt.Sprintf("%s %s: %s → %s", l.verb, field(l.field), l.old, l.new)
+	}
+}
+
+type textFormat struct{}
+
+func (textFormat) begin(io.Writer) error { return nil }
+func (textFormat) end(io.Writer) error   { return nil }
+
+func (textFormat) escape(s string) string { return s }
+
+func (textFormat) line(w io.Writer, l line) error {
+	_, err := fmt.Fprintf(w, "%s %s\n", l.date, l.describe(func(s string) string { return s }))
+	return err
+}
+
+type markdownFormat struct{}
+
+func (markdownFormat) begin(io.Writer) error { return nil }
+func (markdownFormat) end(io.Writer) error   { return nil }
+
+// markdownEscaper escapes the characters that have a meaning inside a line of
+// Markdown, and replaces line breaks so that each change stays on its own line.
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", `*`, `\*`, `_`, `\_`, `~`, `\~`, `[`, `\[`, `]`, `\]`,
+	`<`, `\<`, `>`, `\>`, `|`, `\|`, "\n", " ",
+)
+
+func (markdownFormat) escape(s string) string { return markdownEscaper.Replace(s) }
+
+func (markdownFormat) line(w io.Writer, l line) error {
+	_, err := fmt.Fprintf(w, "- %s %s\n", l.date, l.describe(func(s string) string { return "**" + s + "**" }))
+	return err
+}
+
+type htmlFormat struct{}
+
+func (htmlFormat) begin(w io.Writer) error {
+	_, err := io.WriteString(w, "<ul>\n")
+	return err
+}
+
+func (htmlFormat) end(w io.Writer) error {
+	_, err := io.WriteString(w, "</ul>\n")
+	return err
+}
+
+func (htmlFormat) escape(s string) string { return html.EscapeString(s) }
+
+func (htmlFormat) line(w io.Writer, l line) error {
+	_, err := fmt.Fprintf(w, "<li><time datetime=\"%s\">%s</time> %s</li>\n",
+		l.timestamp.Format(time.RFC3339), l.date, l.describe(func(s string) string { return "<b>" + s + "</b>" }))
+	return err
+}
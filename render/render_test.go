@@ -0,0 +1,165 @@
+package render
+
+import (
+	"bytes"
+	"github.com/snechholt/audit"
+	"testing"
+	"time"
+)
+
+type renderTestObject struct {
+	Status string    `audit:"status"`
+	Title  string    `audit:"title,omitempty"`
+	Due    time.Time `audit:"due,omitempty"`
+}
+
+func renderTestHistory(t *testing.T) (*audit.AuditableValues, audit.AuditableObject) {
+	alice, bob := audit.NewAuditor("user", "alice"), audit.NewAuditor("user", "bob")
+	obj := renderTestObject{Status: "draft", Title: "<Q1> *plan*"}
+	var av audit.AuditableValues
+	sig := audit.NewSignature(alice, time.Date(2024, 5, 1, 22, 30, 0, 0, time.UTC))
+	if _, err := av.Audit(nil, audit.Struct(&obj), sig); err != nil {
+		t.Fatal(err)
+	}
+	old := obj
+	obj.Status = "published"
+	obj.Title = ""
+	obj.Due = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	sig = audit.NewSignature(bob, time.Date(2024, 5, 2, 8, 0, 0, 0, time.UTC))
+	if _, err := av.Audit(audit.Struct(&old), audit.Struct(&obj), sig); err != nil {
+		t.Fatal(err)
+	}
+	return &av, audit.Struct(&obj)
+}
+
+func TestRender(t *testing.T) {
+	oslo, err := time.LoadLocation("Europe/Oslo")
+	if err != nil {
+		t.Skipf("Time zone database not available: %v", err)
+	}
+	tests := []struct {
+		name   string
+		render func(*bytes.Buffer, *audit.AuditableValues, audit.AuditableObject, ...Option) error
+		opts   []Option
+		want   string
+	}{
+		{
+			name: "Text",
+			render: func(b *bytes.Buffer, av *audit.AuditableValues, obj audit.AuditableObject, opts ...Option) error {
+				return Text(b, av, obj, opts...)
+			},
+			want: "2024-05-01 user/alice created status: draft\n" +
+				"2024-05-01 user/alice created title: <Q1> *plan*\n" +
+				"2024-05-02 user/bob added due: 2024-06-01 12:00:00 UTC\n" +
+				"2024-05-02 user/bob changed status: draft → published\n" +
+				"2024-05-02 user/bob removed title (was: <Q1> *plan*)\n",
+		},
+		{
+			name: "Text with options",
+			render: func(b *bytes.Buffer, av *audit.AuditableValues, obj audit.AuditableObject, opts ...Option) error {
+				return Text(b, av, obj, opts...)
+			},
+			opts: []Option{
+				WithLocation(oslo),
+				WithDateLayout("02.01.2006 15:04"),
+				WithTimeLayout("02.01.2006"),
+				WithDisplayNames(map[string]string{"status": "Status", "due": "Due date"}),
+			},
+			want: "02.05.2024 00:30 user/alice created Status: draft\n" +
+				"02.05.2024 00:30 user/alice created title: <Q1> *plan*\n" +
+				"02.05.2024 10:00 user/bob added Due date: 01.06.2024\n" +
+				"02.05.2024 10:00 user/bob changed Status: draft → published\n" +
+				"02.05.2024 10:00 user/bob removed title (was: <Q1> *plan*)\n",
+		},
+		{
+			name: "Markdown",
+			render: func(b *bytes.Buffer, av *audit.AuditableValues, obj audit.AuditableObject, opts ...Option) error {
+				return Markdown(b, av, obj, opts...)
+			},
+			want: "- 2024-05-01 user/alice created **status**: draft\n" +
+				"- 2024-05-01 user/alice created **title**: \\<Q1\\> \\*plan\\*\n" +
+				"- 2024-05-02 user/bob added **due**: 2024-06-01 12:00:00 UTC\n" +
+				"- 2024-05-02 user/bob changed **status**: draft → published\n" +
+				"- 2024-05-02 user/bob removed **title** (was: \\<Q1\\> \\*plan\\*)\n",
+		},
+		{
+			name: "HTML",
+			render: func(b *bytes.Buffer, av *audit.AuditableValues, obj audit.AuditableObject, opts ...Option) error {
+				return HTML(b, av, obj, opts...)
+			},
+			want: "<ul>\n" +
+				"<li><time datetime=\"2024-05-01T22:30:00Z\">2024-05-01</time> user/alice created <b>status</b>: draft</li>\n" +
+				"<li><time datetime=\"2024-05-01T22:30:00Z\">2024-05-01</time> user/alice created <b>title</b>: &lt;Q1&gt; *plan*</li>\n" +
+				"<li><time datetime=\"2024-05-02T08:00:00Z\">2024-05-02</time> user/bob added <b>due</b>: 2024-06-01 12:00:00 UTC</li>\n" +
+				"<li><time datetime=\"2024-05-02T08:00:00Z\">2024-05-02</time> user/bob changed <b>status</b>: draft → published</li>\n" +
+				"<li><time datetime=\"2024-05-02T08:00:00Z\">2024-05-02</time> user/bob removed <b>title</b> (was: &lt;Q1&gt; *plan*)</li>\n" +
+				"</ul>\n",
+		},
+	}
+	for _, test := range tests {
+		av, obj := renderTestHistory(t)
+		var b bytes.Buffer
+		if err := test.render(&b, av, obj, test.opts...); err != nil {
+			t.Fatalf("%s: render error: %v", test.name, err)
+		}
+		if got := b.String(); got != test.want {
+			t.Errorf("%s: wrong output\nWant:\n%s\nGot:\n%s", test.name, test.want, got)
+		}
+	}
+}
+
+func TestRenderOnBehalfOf(t *testing.T) {
+	support, alice := audit.NewAuditor("support", "bob"), audit.NewAuditor("user", "alice")
+	obj := renderTestObject{Status: "draft"}
+	var av audit.AuditableValues
+	sig := audit.NewSignature(support, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), audit.WithOnBehalfOf(alice))
+	if _, err := av.Audit(nil, audit.Struct(&obj), sig); err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := Text(&b, &av, audit.Struct(&obj)); err != nil {
+		t.Fatalf("Text() error: %v", err)
+	}
+	if got, want := b.String(), "2024-05-01 support/bob (on behalf of user/alice) created status: draft\n"; got != want {
+		t.Errorf("Wrong output\nWant: %q\nGot:  %q", want, got)
+	}
+}
+
+func TestRenderAuditorResolver(t *testing.T) {
+	resolver := audit.NewMemoryAuditorResolver(map[audit.Auditor]audit.AuditorInfo{
+		audit.NewAuditor("user", "alice"): {DisplayName: "Alice <Smith>"},
+	})
+	av, obj := renderTestHistory(t)
+	var b bytes.Buffer
+	if err := HTML(&b, av, obj, WithAuditorResolver(resolver)); err != nil {
+		t.Fatalf("HTML() error: %v", err)
+	}
+	want := "<ul>\n" +
+		"<li><time datetime=\"2024-05-01T22:30:00Z\">2024-05-01</time> Alice &lt;Smith&gt; created <b>status</b>: draft</li>\n" +
+		"<li><time datetime=\"2024-05-01T22:30:00Z\">2024-05-01</time> Alice &lt;Smith&gt; created <b>title</b>: &lt;Q1&gt; *plan*</li>\n" +
+		"<li><time datetime=\"2024-05-02T08:00:00Z\">2024-05-02</time> user/bob added <b>due</b>: 2024-06-01 12:00:00 UTC</li>\n" +
+		"<li><time datetime=\"2024-05-02T08:00:00Z\">2024-05-02</time> user/bob changed <b>status</b>: draft → published</li>\n" +
+		"<li><time datetime=\"2024-05-02T08:00:00Z\">2024-05-02</time> user/bob removed <b>title</b> (was: &lt;Q1&gt; *plan*)</li>\n" +
+		"</ul>\n"
+	if got := b.String(); got != want {
+		t.Errorf("Wrong output\nWant:\n%s\nGot:\n%s", want, got)
+	}
+}
+
+func TestRenderCompacted(t *testing.T) {
+	av, obj := renderTestHistory(t)
+	if err := av.Compact(obj, time.Date(2024, 5, 2, 8, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The baseline is not attributed to the auditor of its signature
+	var b bytes.Buffer
+	if err := Text(&b, av, obj); err != nil {
+		t.Fatalf("Text() error: %v", err)
+	}
+	want := "2024-05-02 baseline due: 2024-06-01 12:00:00 UTC\n" +
+		"2024-05-02 baseline status: published\n"
+	if got := b.String(); got != want {
+		t.Errorf("Wrong output\nWant:\n%s\nGot:\n%s", want, got)
+	}
+}
@@ -5,16 +5,81 @@ import (
 	"fmt"
 	"github.com/snechholt/bufrw"
 	"io"
+	"sort"
+	"strings"
 	"time"
 )
 
 type Signature struct {
 	auditor   Auditor
 	timestamp time.Time
+
+	// metadata is nil if the signature has no metadata. It is a pointer to keep
+	// Signature comparable, and must not be modified once the signature is created.
+	metadata *signatureMetadata
+}
+
+// signatureMetadata holds the optional metadata of a signature.
+type signatureMetadata struct {
+	reason string
+	// attributes are ordered by key, with unique keys.
+	attributes []signatureAttribute
+	// onBehalfOf is the auditor that the auditor of the signature acted for, or
+	// the zero Auditor.
+	onBehalfOf Auditor
+}
+
+type signatureAttribute struct {
+	key, value string
+}
+
+// SignatureOption sets optional metadata of a signature created by NewSignature.
+type SignatureOption func(metadata *signatureMetadata)
+
+// WithReason sets the reason for the change that the signature is for.
+func WithReason(reason string) SignatureOption {
+	return func(metadata *signatureMetadata) {
+		metadata.reason = reason
+	}
+}
+
+// WithAttribute sets an attribute of the signature, such as the ID of a ticket or
+// of the request that made the change. Setting an attribute again replaces its
+// value.
+func WithAttribute(key, value string) SignatureOption {
+	return func(metadata *signatureMetadata) {
+		metadata.setAttribute(key, value)
+	}
+}
+
+// WithOnBehalfOf records that the auditor of the signature acted on behalf of
+// another auditor, such as when support staff or a batch job changes data for a
+// customer. The auditor of the signature is the one that actually made the change.
+func WithOnBehalfOf(principal Auditor) SignatureOption {
+	return func(metadata *signatureMetadata) {
+		metadata.onBehalfOf = principal
+	}
 }
 
-func NewSignature(auditor Auditor, timestamp time.Time) Signature {
-	return Signature{auditor: auditor, timestamp: timestamp}
+// WithAttributes sets the attributes of the signature. See WithAttribute.
+func WithAttributes(attributes map[string]string) SignatureOption {
+	return func(metadata *signatureMetadata) {
+		for key, value := range attributes {
+			metadata.setAttribute(key, value)
+		}
+	}
+}
+
+func NewSignature(auditor Auditor, timestamp time.Time, opts ...SignatureOption) Signature {
+	sig := Signature{auditor: auditor, timestamp: timestamp}
+	if len(opts) > 0 {
+		var metadata signatureMetadata
+		for _, opt := range opts {
+			opt(&metadata)
+		}
+		sig.metadata = metadata.orNil()
+	}
+	return sig
 }
 
 func (sig Signature) Auditor() Auditor {
@@ -25,12 +90,152 @@ func (sig Signature) Timestamp() time.Time {
 	return sig.timestamp
 }
 
+// Reason returns the reason set with WithReason, or an empty string if none is set.
+func (sig Signature) Reason() string {
+	if sig.metadata == nil {
+		return ""
+	}
+	return sig.metadata.reason
+}
+
+// Attribute returns the value of the attribute set with WithAttribute, and whether
+// it is set.
+func (sig Signature) Attribute(key string) (string, bool) {
+	if sig.metadata == nil {
+		return "", false
+	}
+	i := sig.metadata.indexOf(key)
+	if i < 0 {
+		return "", false
+	}
+	return sig.metadata.attributes[i].value, true
+}
+
+// Attributes returns a copy of the attributes of the signature, or nil if it has
+// none.
+func (sig Signature) Attributes() map[string]string {
+	if sig.metadata == nil || len(sig.metadata.attributes) == 0 {
+		return nil
+	}
+	attributes := make(map[string]string, len(sig.metadata.attributes))
+	for _, attr := range sig.metadata.attributes {
+		attributes[attr.key] = attr.value
+	}
+	return attributes
+}
+
+// OnBehalfOf returns the auditor set with WithOnBehalfOf, or the zero Auditor if the
+// auditor of the signature did not act on behalf of another.
+func (sig Signature) OnBehalfOf() Auditor {
+	if sig.metadata == nil {
+		return Auditor{}
+	}
+	return sig.metadata.onBehalfOf
+}
+
 func (sig Signature) IsZero() bool {
 	return sig == Signature{}
 }
 
 func (sig Signature) Equal(other Signature) bool {
-	return sig.auditor.Equal(other.auditor) && sig.timestamp.Equal(other.timestamp)
+	return sig.auditor.Equal(other.auditor) && sig.timestamp.Equal(other.timestamp) &&
+		sig.metadata.equal(other.metadata)
+}
+
+func (metadata *signatureMetadata) setAttribute(key, value string) {
+	if i := metadata.indexOf(key); i >= 0 {
+		metadata.attributes[i].value = value
+		return
+	}
+	i := sort.Search(len(metadata.attributes), func(i int) bool { return metadata.attributes[i].key >= key })
+	metadata.attributes = append(metadata.attributes, signatureAttribute{})
+	copy(metadata.attributes[i+1:], metadata.attributes[i:])
+	metadata.attributes[i] = signatureAttribute{key: key, value: value}
+}
+
+func (metadata *signatureMetadata) indexOf(key string) int {
+	i := sort.Search(len(metadata.attributes), func(i int) bool { return metadata.attributes[i].key >= key })
+	if i < len(metadata.attributes) && metadata.attributes[i].key == key {
+		return i
+	}
+	return -1
+}
+
+// orNil returns nil if the metadata is empty, and the metadata otherwise.
+func (metadata *signatureMetadata) orNil() *signatureMetadata {
+	if metadata.reason == "" && len(metadata.attributes) == 0 && metadata.onBehalfOf.IsZero() {
+		return nil
+	}
+	return metadata
+}
+
+func (metadata *signatureMetadata) equal(other *signatureMetadata) bool {
+	if metadata == nil || other == nil {
+		return metadata == other
+	}
+	if metadata.reason != other.reason || !metadata.onBehalfOf.Equal(other.onBehalfOf) ||
+		len(metadata.attributes) != len(other.attributes) {
+		return false
+	}
+	for i, attr := range metadata.attributes {
+		if attr != other.attributes[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// writeSignatureMetadata writes metadata, which may be nil.
+func writeSignatureMetadata(w *bufrw.Writer, metadata *signatureMetadata) error {
+	var reason string
+	var attributes []string
+	if metadata != nil {
+		reason = metadata.reason
+		for _, attr := range metadata.attributes {
+			attributes = append(attributes, attr.key, attr.value)
+		}
+	}
+	if err := w.WriteString(reason); err != nil {
+		return err
+	}
+	if err := w.WriteStrings(attributes...); err != nil {
+		return err
+	}
+	var onBehalfOf string
+	if metadata != nil && !metadata.onBehalfOf.IsZero() {
+		onBehalfOf = metadata.onBehalfOf.Encode()
+	}
+	return w.WriteString(onBehalfOf)
+}
+
+// readSignatureMetadata reads metadata written by writeSignatureMetadata. It returns
+// nil if the metadata is empty.
+func readSignatureMetadata(r *bufrw.Reader) (*signatureMetadata, error) {
+	var metadata signatureMetadata
+	var err error
+	if metadata.reason, err = r.ReadString(); err != nil {
+		return nil, err
+	}
+	attributes, err := r.ReadStrings()
+	if err != nil {
+		return nil, err
+	}
+	if len(attributes)%2 != 0 {
+		return nil, fmt.Errorf("invalid signature attributes: odd number of strings")
+	}
+	for i := 0; i < len(attributes); i += 2 {
+		metadata.setAttribute(attributes[i], attributes[i+1])
+	}
+	onBehalfOf, err := r.ReadString()
+	if err != nil {
+		return nil, err
+	}
+	if onBehalfOf != "" {
+		if err := metadata.onBehalfOf.Decode(onBehalfOf); err != nil {
+			return nil, err
+		}
+	}
+	return metadata.orNil(), nil
 }
 
 // func (sig Signature) Encode() string {
@@ -51,12 +256,18 @@ func (sig Signature) Serialize() ([]byte, error) {
 }
 
 func (sig Signature) SerializeToBufRW(w io.Writer, buf *bufrw.Buffer) error {
-	if loc, offset := sig.timestamp.Zone(); offset != 0 {
-		return fmt.Errorf("invalid signature timestamp: must be in zone with offset = 0, was %s (%d)", loc, offset)
+	unixNano, err := sig.unixNano()
+	if err != nil {
+		return err
 	}
 
-	// Write version number
-	if err := buf.WriteInt(w, 1); err != nil {
+	// Write version number. Version 2 adds the metadata, and is only written for
+	// signatures that have any.
+	version := 1
+	if sig.metadata != nil {
+		version = 2
+	}
+	if err := buf.WriteInt(w, version); err != nil {
 		return err
 	}
 
@@ -66,6 +277,22 @@ func (sig Signature) SerializeToBufRW(w io.Writer, buf *bufrw.Buffer) error {
 	}
 
 	// Write timestamp
+	if err := buf.WriteInt64(w, unixNano); err != nil {
+		return err
+	}
+
+	// Write metadata
+	if version >= 2 {
+		return writeSignatureMetadata(buf.Writer(w), sig.metadata)
+	}
+	return nil
+}
+
+// unixNano returns the timestamp as serialized, which is 0 for the zero time.
+func (sig Signature) unixNano() (int64, error) {
+	if loc, offset := sig.timestamp.Zone(); offset != 0 {
+		return 0, fmt.Errorf("invalid signature timestamp: must be in zone with offset = 0, was %s (%d)", loc, offset)
+	}
 
 	// Serialization of Signature encodes the timestamp using UnixNano(), which as some
 	// limitations in the range of possible values. The min and max values are described
@@ -80,14 +307,26 @@ func (sig Signature) SerializeToBufRW(w io.Writer, buf *bufrw.Buffer) error {
 	)
 	t := sig.timestamp
 	if !t.IsZero() && (t.Before(minAllowed) || t.After(maxAllowed)) {
-		return fmt.Errorf("signature timestamp %s is out of bounds", sig.timestamp)
+		return 0, fmt.Errorf("signature timestamp %s is out of bounds", sig.timestamp)
 	}
 	var unixNano int64
 	if !t.IsZero() {
 		unixNano = t.UnixNano()
 	}
+	return unixNano, nil
+}
 
-	return buf.WriteInt64(w, unixNano)
+// timestampFromUnixNano returns the timestamp serialized as unixNano. See
+// Signature.unixNano.
+func timestampFromUnixNano(unixNano int64) (time.Time, error) {
+	var timestamp time.Time
+	if unixNano < 0 {
+		return time.Time{}, fmt.Errorf("invalid unix nano value found: %d", unixNano)
+	}
+	if unixNano > 0 {
+		timestamp = time.Unix(0, unixNano).In(time.UTC) // .In(time.UTC) is because of local timezone screwing up tests
+	}
+	return timestamp, nil
 }
 
 func (sig *Signature) Deserialize(b []byte) error {
@@ -100,7 +339,7 @@ func (sig *Signature) DeserializeFromBufRW(r io.Reader, buf *bufrw.Buffer) error
 	if err != nil {
 		return err
 	}
-	if version != 1 {
+	if version < 1 || version > 2 {
 		return fmt.Errorf("unsupported version number: %d", version)
 	}
 
@@ -113,29 +352,65 @@ func (sig *Signature) DeserializeFromBufRW(r io.Reader, buf *bufrw.Buffer) error
 		return err
 	}
 
-	var timestamp time.Time
 	unixNano, err := buf.ReadInt64(r)
 	if err != nil {
 		return err
 	}
-	if unixNano < 0 {
-		return fmt.Errorf("invalid unix nano value found: %d", unixNano)
+	timestamp, err := timestampFromUnixNano(unixNano)
+	if err != nil {
+		return err
 	}
-	if unixNano > 0 {
-		timestamp = time.Unix(0, unixNano).In(time.UTC) // .In(time.UTC) is because of local timezone screwing up tests
+
+	var metadata *signatureMetadata
+	if version >= 2 {
+		if metadata, err = readSignatureMetadata(buf.Reader(r)); err != nil {
+			return err
+		}
 	}
 
 	sig.auditor = auditor
 	sig.timestamp = timestamp
+	sig.metadata = metadata
 
 	return nil
 }
 
+// String returns the signature as auditor@timestamp, followed by the auditor acted on
+// behalf of and the reason and attributes in parentheses, if set, as in
+// "support/7@2010-01-01T12:00:00Z on behalf of user/1 (Fix typo; ticket=ABC-1)".
 func (sig Signature) String() string {
+	return sig.format(Auditor.String)
+}
+
+// format formats the signature as described by String, with the auditors formatted
+// by formatAuditor.
+func (sig Signature) format(formatAuditor func(Auditor) string) string {
+	layout := time.RFC3339
 	if sig.timestamp.Nanosecond() > 0 {
-		return fmt.Sprintf("%s@%s", sig.auditor, sig.timestamp.Format(time.RFC3339Nano))
+		layout = time.RFC3339Nano
+	}
+	s := fmt.Sprintf("%s@%s", formatAuditor(sig.auditor), sig.timestamp.Format(layout))
+	if sig.metadata == nil {
+		return s
+	}
+	if !sig.metadata.onBehalfOf.IsZero() {
+		s += " on behalf of " + formatAuditor(sig.metadata.onBehalfOf)
+	}
+	var parts []string
+	if sig.metadata.reason != "" {
+		parts = append(parts, sig.metadata.reason)
+	}
+	if len(sig.metadata.attributes) > 0 {
+		attributes := make([]string, len(sig.metadata.attributes))
+		for i, attr := range sig.metadata.attributes {
+			attributes[i] = attr.key + "=" + attr.value
+		}
+		parts = append(parts, strings.Join(attributes, ", "))
+	}
+	if len(parts) == 0 {
+		return s
 	}
-	return fmt.Sprintf("%s@%s", sig.auditor, sig.timestamp.Format(time.RFC3339))
+	return fmt.Sprintf("%s (%s)", s, strings.Join(parts, "; "))
 }
 
 type SignatureSlice []Signature
@@ -1,7 +1,9 @@
 package audit
 
 import (
+	"encoding/json"
 	"fmt"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -69,6 +71,7 @@ func TestSignatureSerialization(t *testing.T) {
 		NewAuditor("kind", ""),
 		NewAuditor("", "id"),
 		NewAuditor("kind", "id"),
+		NewAuditor("service", "batch/nightly"),
 	}
 	timestamps := []time.Time{
 		{},
@@ -114,4 +117,203 @@ func gotError(want, got error) bool {
 		return got == nil
 	}
 	return got != nil && got.Error() == want.Error()
-}
\ No newline at end of file
+}
+
+func TestSignatureMetadata(t *testing.T) {
+	auditor := NewAuditor("user", "1")
+	t1 := time.Date(2010, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	sig := NewSignature(auditor, t1,
+		WithReason("Fix typo"),
+		WithAttribute("ticket", "ABC-1"),
+		WithAttributes(map[string]string{"request": "r1", "ticket": "ABC-2"}))
+	if got, want := sig.Reason(), "Fix typo"; got != want {
+		t.Errorf("Reason() = %q, want %q", got, want)
+	}
+	if got, ok := sig.Attribute("ticket"); !ok || got != "ABC-2" {
+		t.Errorf("Attribute(ticket) = %q, %v, want ABC-2, true", got, ok)
+	}
+	if got, ok := sig.Attribute("missing"); ok {
+		t.Errorf("Attribute(missing) = %q, true, want false", got)
+	}
+	if got, want := sig.Attributes(), map[string]string{"request": "r1", "ticket": "ABC-2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Attributes() = %v, want %v", got, want)
+	}
+	if got, want := sig.String(), "user/1@2010-01-01T12:00:00Z (Fix typo; request=r1, ticket=ABC-2)"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+
+	// Empty metadata is the same as no metadata
+	if empty := NewSignature(auditor, t1, WithReason(""), WithAttributes(nil)); empty != NewSignature(auditor, t1) {
+		t.Errorf("Signature with empty metadata differs from signature without metadata")
+	}
+
+	tests := []Signature{
+		NewSignature(auditor, t1),
+		NewSignature(auditor, t1, WithReason("Fix typo")),
+		NewSignature(auditor, t1, WithReason("Fix other typo")),
+		NewSignature(auditor, t1, WithAttribute("ticket", "ABC-1")),
+		NewSignature(auditor, t1, WithAttribute("ticket", "ABC-2")),
+		NewSignature(auditor, t1, WithAttribute("request", "ABC-1")),
+		sig,
+	}
+	for i, a1 := range tests {
+		for j, a2 := range tests {
+			if got, want := a1.Equal(a2), i == j; got != want {
+				t.Errorf("%s.Equal(%s) == %v, want %v", a1, a2, got, want)
+			}
+		}
+
+		b, err := a1.Serialize()
+		if err != nil {
+			t.Fatalf("%s.Serialize() error: %v", a1, err)
+		}
+		// Only signatures with metadata are written in version 2
+		wantVersion := byte(2)
+		if i == 0 {
+			wantVersion = 1
+		}
+		if b[3] != wantVersion {
+			t.Errorf("%s.Serialize() wrote version %d, want %d", a1, b[3], wantVersion)
+		}
+		var got Signature
+		if err := got.Deserialize(b); err != nil {
+			t.Fatalf("Deserialize(%s.Serialize()) error: %v", a1, err)
+		}
+		if !got.Equal(a1) {
+			t.Errorf("%s -> %s", a1, got)
+		}
+	}
+}
+
+func TestAuditableValuesSignatureMetadata(t *testing.T) {
+	auditor := NewAuditor("user", "1")
+	t1 := time.Date(2010, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	obj := &auditableObject{Values: map[string]interface{}{"A": 1}}
+	var av AuditableValues
+	if _, err := av.Audit(nil, obj, NewSignature(auditor, t1)); err != nil {
+		t.Fatal(err)
+	}
+	cpy := obj.Copy()
+	obj.Values = map[string]interface{}{"A": 2}
+	sig := NewSignature(auditor, t1.Add(time.Hour), WithReason("Fix typo"), WithAttribute("ticket", "ABC-1"))
+	if _, err := av.Audit(cpy, obj, sig); err != nil {
+		t.Fatal(err)
+	}
+
+	// The metadata is kept by all formats
+	for _, version := range []byte{1, serializationVersion} {
+		var got AuditableValues
+		if err := got.Deserialize(serializeVersion(t, &av, version)); err != nil {
+			t.Fatalf("Deserialize(version %d) error: %v", version, err)
+		}
+		if latest := got.LatestSignature(); !latest.Equal(sig) {
+			t.Errorf("Wrong latest signature from version %d: %s, want %s", version, latest, sig)
+		}
+	}
+	b, err := json.Marshal(&av)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fromJSON AuditableValues
+	if err := json.Unmarshal(b, &fromJSON); err != nil {
+		t.Fatal(err)
+	}
+	if latest := fromJSON.LatestSignature(); !latest.Equal(sig) {
+		t.Errorf("Wrong latest signature from JSON: %s, want %s", latest, sig)
+	}
+
+	// The metadata is covered by the hash chain
+	if err := av.Verify(); err != nil {
+		t.Fatalf("Verify() error: %v", err)
+	}
+	av.history[1].signature = NewSignature(auditor, sig.Timestamp(), WithReason("Other reason"), WithAttribute("ticket", "ABC-1"))
+	if err := av.Verify(); err == nil {
+		t.Errorf("Verify() after changing the reason: want error, got nil")
+	}
+}
+
+func TestSignatureOnBehalfOf(t *testing.T) {
+	support := NewAuditor("support", "7")
+	customer := NewAuditor("user", "1")
+	t1 := time.Date(2010, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	sig := NewSignature(support, t1, WithOnBehalfOf(customer))
+	if got := sig.OnBehalfOf(); !got.Equal(customer) {
+		t.Errorf("OnBehalfOf() = %s, want %s", got, customer)
+	}
+	if got := NewSignature(support, t1).OnBehalfOf(); !got.IsZero() {
+		t.Errorf("OnBehalfOf() without delegation = %s, want zero auditor", got)
+	}
+	if got, want := sig.String(), "support/7@2010-01-01T12:00:00Z on behalf of user/1"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	withReason := NewSignature(support, t1, WithOnBehalfOf(customer), WithReason("Reset password"))
+	if got, want := withReason.String(), "support/7@2010-01-01T12:00:00Z on behalf of user/1 (Reset password)"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+
+	tests := []Signature{
+		NewSignature(support, t1),
+		NewSignature(support, t1, WithReason("Reset password")),
+		sig,
+		NewSignature(support, t1, WithOnBehalfOf(NewAuditor("user", "2"))),
+		withReason,
+	}
+	for i, a1 := range tests {
+		for j, a2 := range tests {
+			if got, want := a1.Equal(a2), i == j; got != want {
+				t.Errorf("%s.Equal(%s) == %v, want %v", a1, a2, got, want)
+			}
+		}
+
+		b, err := a1.Serialize()
+		if err != nil {
+			t.Fatalf("%s.Serialize() error: %v", a1, err)
+		}
+		// Only signatures with metadata are written in version 2
+		wantVersion := []byte{1, 2, 2, 2, 2}[i]
+		if b[3] != wantVersion {
+			t.Errorf("%s.Serialize() wrote version %d, want %d", a1, b[3], wantVersion)
+		}
+		var got Signature
+		if err := got.Deserialize(b); err != nil {
+			t.Fatalf("Deserialize(%s.Serialize()) error: %v", a1, err)
+		}
+		if !got.Equal(a1) {
+			t.Errorf("%s -> %s", a1, got)
+		}
+	}
+
+	// The auditor acted on behalf of is kept by the serialization and JSON encoding
+	// of the history, and covered by the hash chain
+	obj := &auditableObject{Values: map[string]interface{}{"A": 1}}
+	var av AuditableValues
+	if _, err := av.Audit(nil, obj, sig); err != nil {
+		t.Fatal(err)
+	}
+	var deserialized, fromJSON AuditableValues
+	b, err := av.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := deserialized.Deserialize(b); err != nil {
+		t.Fatal(err)
+	}
+	if b, err = json.Marshal(&av); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, &fromJSON); err != nil {
+		t.Fatal(err)
+	}
+	for name, values := range map[string]*AuditableValues{"Deserialize": &deserialized, "JSON": &fromJSON} {
+		if got := values.CreationSignature(); !got.Equal(sig) {
+			t.Errorf("Wrong signature from %s: %s, want %s", name, got, sig)
+		}
+	}
+	av.history[0].signature = NewSignature(support, t1, WithOnBehalfOf(NewAuditor("user", "2")))
+	if err := av.Verify(); err == nil {
+		t.Errorf("Verify() after changing the auditor acted on behalf of: want error, got nil")
+	}
+}
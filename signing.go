@@ -0,0 +1,86 @@
+package audit
+
+import (
+	"crypto/ed25519"
+	"fmt"
+)
+
+// SignLatest signs the latest history entry with the ed25519 private key of the
+// auditor of the entry, which allows VerifySignatures to prove that the entry was
+// recorded by the holder of the key. It is typically called right after Audit
+// reports a change. Signing is optional, and entries that are not signed are
+// reported as unsigned by VerifySignatures.
+//
+// The signature covers the hash of the entry (see Verify), and thereby the
+// signature and fields of the entry as well as all entries before it.
+func (values *AuditableValues) SignLatest(key ed25519.PrivateKey) error {
+	n := len(values.history)
+	if n == 0 {
+		return fmt.Errorf("cannot sign an empty history")
+	}
+	if len(key) != ed25519.PrivateKeySize {
+		return fmt.Errorf("invalid private key length: %d", len(key))
+	}
+	h := &values.history[n-1]
+	if h.keySignature != nil {
+		return fmt.Errorf("history entry %s is already signed", h.signature)
+	}
+	h.keySignature = ed25519.Sign(key, h.hash)
+	return nil
+}
+
+// UnverifiedEntry describes a history entry that could not be verified by
+// VerifySignatures.
+type UnverifiedEntry struct {
+	// Index is the index of the entry, where the creation of the object is entry 0.
+	Index     int
+	Signature Signature
+	// Unsigned is true if the entry is not signed, and false if the signature of the
+	// entry is invalid.
+	Unsigned bool
+}
+
+func (entry UnverifiedEntry) String() string {
+	if entry.Unsigned {
+		return fmt.Sprintf("history entry %d (%s) is not signed", entry.Index, entry.Signature)
+	}
+	return fmt.Sprintf("history entry %d (%s) has an invalid signature", entry.Index, entry.Signature)
+}
+
+// VerifySignatures verifies the ed25519 signatures of the history entries, using
+// publicKey to look up the public key of the auditor of each entry. It returns
+// the entries that are unsigned or whose signature is invalid, which is the case
+// if the entry, or any entry before it, has been modified after it was signed.
+// An error is returned if publicKey fails, and a *SnapshotError if the snapshots
+// of the fields do not match the history, as with Verify.
+func (values *AuditableValues) VerifySignatures(publicKey func(Auditor) (ed25519.PublicKey, error)) ([]UnverifiedEntry, error) {
+	var unverified []UnverifiedEntry
+	var prev []byte
+	for i, h := range values.history {
+		// Verify against the hash of the content rather than the stored hash, so
+		// that modified entries are detected even if their hashes are updated
+		hash, err := h.chainHash(prev)
+		if err != nil {
+			return nil, err
+		}
+		prev = hash
+		if h.keySignature == nil {
+			unverified = append(unverified, UnverifiedEntry{Index: i, Signature: h.signature, Unsigned: true})
+			continue
+		}
+		key, err := publicKey(h.signature.auditor)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get public key of auditor %s: %w", h.signature.auditor, err)
+		}
+		if len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid public key length for auditor %s: %d", h.signature.auditor, len(key))
+		}
+		if !ed25519.Verify(key, hash, h.keySignature) {
+			unverified = append(unverified, UnverifiedEntry{Index: i, Signature: h.signature})
+		}
+	}
+	if err := values.verifySnapshots(); err != nil {
+		return nil, err
+	}
+	return unverified, nil
+}
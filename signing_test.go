@@ -0,0 +1,117 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestAuditableValuesSignatures(t *testing.T) {
+	getSig := new(signatureGenerator).Next
+
+	alice, bob := NewAuditor("user", "alice"), NewAuditor("user", "bob")
+	keys := make(map[Auditor]ed25519.PrivateKey)
+	for _, auditor := range []Auditor{alice, bob} {
+		_, key, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[auditor] = key
+	}
+	publicKey := func(auditor Auditor) (ed25519.PublicKey, error) {
+		key, ok := keys[auditor]
+		if !ok {
+			return nil, fmt.Errorf("unknown auditor")
+		}
+		return key.Public().(ed25519.PublicKey), nil
+	}
+
+	// Alice creates the object and bob updates it twice, signing only the first update
+	obj := &auditableObject{Values: map[string]interface{}{"status": "status-0"}}
+	var av AuditableValues
+	var sigs []Signature
+	for i, auditor := range []Auditor{alice, bob, bob} {
+		sig := getSig()
+		sig.auditor = auditor
+		if i == 0 {
+			if _, err := av.Audit(nil, obj, sig); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			cpy := obj.Copy()
+			obj.Values["status"] = fmt.Sprintf("status-%d", i)
+			if _, err := av.Audit(cpy, obj, sig); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if i < 2 {
+			if err := av.SignLatest(keys[auditor]); err != nil {
+				t.Fatalf("SignLatest() error: %v", err)
+			}
+		}
+		sigs = append(sigs, sig)
+	}
+	if err := av.SignLatest(keys[bob]); err != nil {
+		t.Fatalf("SignLatest() error: %v", err)
+	}
+	wantErr := fmt.Errorf("history entry %s is already signed", sigs[2])
+	if err := av.SignLatest(keys[bob]); !gotError(wantErr, err) {
+		t.Errorf("Wrong error from signing a signed entry\nWant %v\nGot  %v", wantErr, err)
+	}
+	av.history[2].keySignature = nil
+
+	b, err := av.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error: %v", err)
+	}
+	var got AuditableValues
+	if err := got.Deserialize(b); err != nil {
+		t.Fatalf("Deserialize() error: %v", err)
+	}
+	if !reflect.DeepEqual(av, got) {
+		t.Errorf("Wrong value after serialize/deserialize\nWant %v\nGot  %v", av, got)
+	}
+
+	unverified, err := got.VerifySignatures(publicKey)
+	if err != nil {
+		t.Fatalf("VerifySignatures() error: %v", err)
+	}
+	want := []UnverifiedEntry{{Index: 2, Signature: sigs[2], Unsigned: true}}
+	if !reflect.DeepEqual(unverified, want) {
+		t.Errorf("Wrong unverified entries\nWant %v\nGot  %v", want, unverified)
+	}
+
+	// Modifying a value invalidates the signature of the entry holding it, and of all
+	// entries after it. The history of the first update holds the value set by alice.
+	tampered := bytes.Replace(b, []byte("status-0"), []byte("status-9"), 1)
+	if err := got.Deserialize(tampered); err != nil {
+		t.Fatalf("Deserialize() error: %v", err)
+	}
+	if unverified, err = got.VerifySignatures(publicKey); err != nil {
+		t.Fatalf("VerifySignatures() error: %v", err)
+	}
+	want = []UnverifiedEntry{{Index: 1, Signature: sigs[1]}, {Index: 2, Signature: sigs[2], Unsigned: true}}
+	if !reflect.DeepEqual(unverified, want) {
+		t.Errorf("Wrong unverified entries after tampering\nWant %v\nGot  %v", want, unverified)
+	}
+
+	// Forging an entry in the name of another auditor makes its signature invalid
+	got = av
+	got.history = append([]auditHistory(nil), av.history...)
+	got.history[1].signature.auditor = alice
+	if unverified, err = got.VerifySignatures(publicKey); err != nil {
+		t.Fatalf("VerifySignatures() error: %v", err)
+	}
+	if len(unverified) == 0 || unverified[0].Index != 1 || unverified[0].Unsigned {
+		t.Errorf("Forged entry was not reported as invalid: %v", unverified)
+	}
+
+	// Errors looking up keys are returned
+	wantErr = fmt.Errorf("cannot get public key of auditor user/alice: unknown auditor")
+	noKeys := func(Auditor) (ed25519.PublicKey, error) { return nil, fmt.Errorf("unknown auditor") }
+	if _, err := av.VerifySignatures(noKeys); !gotError(wantErr, err) {
+		t.Errorf("Wrong error from VerifySignatures() with failing key lookup\nWant %v\nGot  %v", wantErr, err)
+	}
+}
@@ -0,0 +1,375 @@
+package audit
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StructObject is an AuditableObject backed by a pointer to a struct. The fields
+// of the struct are mapped to audited fields using reflection, so that domain
+// types do not need to implement GetFields and SetFields by hand.
+//
+// All exported fields of the struct are audited, using the Go field name as the
+// field name. This can be customized with the "audit" struct tag:
+//
+//	Name    string    `audit:"name"`           // Audited as "name"
+//	Note    string    `audit:"note,omitempty"` // Left out of the fields when empty
+//	Cache   string    `audit:"-"`              // Not audited
+//	AsOf    time.Time `audit:",rollback"`      // Holds the rollback timestamp
+//
+// Fields with the omitempty option are left out of the audited fields when they
+// hold the zero value of their type, and are reported as added or removed when
+// they become non-zero or zero. When an object is rolled back, fields that are
+// not present at the rollback time are set to their zero value.
+//
+// Fields of types registered with RegisterValueType are audited as values of
+// that type. Since the mapping of a struct type is determined the first time it
+// is used, custom value types must be registered before that.
+//
+// Nested structs and pointers to structs are audited as nested objects, so that
+// each of their fields is recorded under its path, such as "address.city".
+//
+// The rollback option marks a time.Time field that stores the timestamp the
+// object is rolled back to. Without it, the timestamp is only tracked by the
+// StructObject itself, and is lost if the struct is wrapped again.
+type StructObject struct {
+	v         reflect.Value
+	info      *structInfo
+	tRollback time.Time
+}
+
+// Struct returns an AuditableObject for the struct that ptr points to. It panics
+// if ptr is not a non-nil pointer to a struct. Fields of unsupported types are
+// reported as errors by GetFields and SetFields.
+func Struct(ptr interface{}) *StructObject {
+	v := reflect.ValueOf(ptr)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		panic(fmt.Sprintf("audit.Struct: expected a non-nil pointer to a struct, got %T", ptr))
+	}
+	v = v.Elem()
+	return &StructObject{v: v, info: getStructInfo(v.Type())}
+}
+
+// GetFields returns the audited fields of the struct along with the timestamp
+// the struct is rolled back to.
+func (obj *StructObject) GetFields() ([]Field, time.Time, error) {
+	fields, err := obj.info.getFields(obj.v)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return fields, obj.RollbackTime(), nil
+}
+
+// SetFields sets the struct fields to the given values. Audited struct fields that
+// are not present in fields are set to their zero value.
+func (obj *StructObject) SetFields(fields []Field, tRollback time.Time) error {
+	if err := obj.info.setFields(obj.v, fields); err != nil {
+		return err
+	}
+	obj.tRollback = tRollback
+	if obj.info.rollback != nil {
+		obj.v.FieldByIndex(obj.info.rollback).Set(reflect.ValueOf(tRollback))
+	}
+	return nil
+}
+
+// RollbackTime returns the timestamp the struct is rolled back to, or the zero
+// time if it is not rolled back.
+func (obj *StructObject) RollbackTime() time.Time {
+	if obj.info.rollback != nil {
+		return obj.v.FieldByIndex(obj.info.rollback).Interface().(time.Time)
+	}
+	return obj.tRollback
+}
+
+type structInfo struct {
+	fields   []structField
+	rollback []int // index of the field tagged with the rollback option, if any
+	err      error
+}
+
+func (info *structInfo) getFields(v reflect.Value) ([]Field, error) {
+	if info.err != nil {
+		return nil, info.err
+	}
+	fields := make([]Field, 0, len(info.fields))
+	for _, sf := range info.fields {
+		rv := v.FieldByIndex(sf.index)
+		if sf.omitEmpty && rv.IsZero() {
+			continue
+		}
+		value, err := sf.codec.get(rv)
+		if err != nil {
+			return nil, fmt.Errorf("error getting field %s: %w", sf.name, err)
+		}
+		fields = append(fields, Field{Name: sf.name, Value: value})
+	}
+	return fields, nil
+}
+
+func (info *structInfo) setFields(v reflect.Value, fields fieldSlice) error {
+	if info.err != nil {
+		return info.err
+	}
+	for _, field := range fields {
+		if info.indexOf(field.Name) == -1 {
+			return fmt.Errorf("unknown field %s for type %s", field.Name, v.Type())
+		}
+	}
+	for _, sf := range info.fields {
+		rv := v.FieldByIndex(sf.index)
+		field, ok := fields.TryGet(sf.name)
+		if !ok {
+			rv.Set(reflect.Zero(rv.Type()))
+			continue
+		}
+		if err := sf.codec.set(rv, field.Value); err != nil {
+			return fmt.Errorf("error setting field %s: %w", sf.name, err)
+		}
+	}
+	return nil
+}
+
+func (info *structInfo) indexOf(name string) int {
+	for i, sf := range info.fields {
+		if sf.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+type structField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+	codec     structFieldCodec
+}
+
+var structInfoCache sync.Map // map[reflect.Type]*structInfo
+
+func getStructInfo(t reflect.Type) *structInfo {
+	if info, ok := structInfoCache.Load(t); ok {
+		return info.(*structInfo)
+	}
+	info := new(structInfo)
+	info.fields, info.rollback, info.err = parseStruct(t)
+	cached, _ := structInfoCache.LoadOrStore(t, info)
+	return cached.(*structInfo)
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func parseStruct(t reflect.Type) ([]structField, []int, error) {
+	var (
+		fields   []structField
+		rollback []int
+	)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag := f.Tag.Get("audit")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = f.Name
+		}
+		sf := structField{name: name, index: f.Index}
+		isRollback := false
+		for _, opt := range strings.Split(opts, ",") {
+			switch opt {
+			case "":
+			case "omitempty":
+				sf.omitEmpty = true
+			case "rollback":
+				isRollback = true
+			default:
+				return nil, nil, fmt.Errorf("invalid audit tag option %q on field %s.%s", opt, t, f.Name)
+			}
+		}
+		if isRollback {
+			if f.Type != timeType {
+				return nil, nil, fmt.Errorf("rollback field %s.%s must be of type time.Time, was %s", t, f.Name, f.Type)
+			}
+			if rollback != nil {
+				return nil, nil, fmt.Errorf("multiple rollback fields in type %s", t)
+			}
+			rollback = f.Index
+			continue
+		}
+		codec, err := getStructFieldCodec(f.Type)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot audit field %s.%s: %w", t, f.Name, err)
+		}
+		sf.codec = codec
+		for _, other := range fields {
+			if other.name == sf.name {
+				return nil, nil, fmt.Errorf("duplicate audited field name %s in type %s", sf.name, t)
+			}
+		}
+		fields = append(fields, sf)
+	}
+	return fields, rollback, nil
+}
+
+// structValueTypes maps the kinds of struct fields to the field value type
+// they are audited as. Named types (such as type Status string) are converted
+// to and from the type of their kind.
+var structValueTypes = map[reflect.Kind]reflect.Type{
+	reflect.String:  reflect.TypeOf(""),
+	reflect.Bool:    reflect.TypeOf(false),
+	reflect.Int:     reflect.TypeOf(int(0)),
+	reflect.Int8:    reflect.TypeOf(int8(0)),
+	reflect.Int16:   reflect.TypeOf(int16(0)),
+	reflect.Int32:   reflect.TypeOf(int32(0)),
+	reflect.Int64:   reflect.TypeOf(int64(0)),
+	reflect.Uint:    reflect.TypeOf(uint(0)),
+	reflect.Uint8:   reflect.TypeOf(uint8(0)),
+	reflect.Uint16:  reflect.TypeOf(uint16(0)),
+	reflect.Uint32:  reflect.TypeOf(uint32(0)),
+	reflect.Uint64:  reflect.TypeOf(uint64(0)),
+	reflect.Float32: reflect.TypeOf(float32(0)),
+	reflect.Float64: reflect.TypeOf(float64(0)),
+}
+
+// structFieldCodec converts between a struct field and its audited field value.
+type structFieldCodec struct {
+	get func(rv reflect.Value) (interface{}, error)
+	set func(rv reflect.Value, value interface{}) error
+}
+
+func getStructFieldCodec(t reflect.Type) (structFieldCodec, error) {
+	if _, ok := lookupValueTypeOf(t); ok {
+		return scalarFieldCodec(t), nil
+	}
+	if valueType, ok := structValueTypes[t.Kind()]; ok {
+		return scalarFieldCodec(valueType), nil
+	}
+	if t == timeType {
+		return scalarFieldCodec(timeType), nil
+	}
+	if t.Kind() == reflect.Struct && t != timeType {
+		return nestedFieldCodec(t), nil
+	}
+	if t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Struct && t.Elem() != timeType {
+		return nestedPtrFieldCodec(t.Elem()), nil
+	}
+	if t.Kind() == reflect.Slice {
+		elemType := t.Elem()
+		valueElemType, ok := structValueTypes[elemType.Kind()]
+		if elemType == timeType {
+			valueElemType, ok = timeType, true
+		}
+		if ok {
+			return sliceFieldCodec(reflect.SliceOf(valueElemType)), nil
+		}
+	}
+	return structFieldCodec{}, fmt.Errorf("unsupported type %s", t)
+}
+
+func scalarFieldCodec(valueType reflect.Type) structFieldCodec {
+	return structFieldCodec{
+		get: func(rv reflect.Value) (interface{}, error) {
+			return rv.Convert(valueType).Interface(), nil
+		},
+		set: func(rv reflect.Value, value interface{}) error {
+			v := reflect.ValueOf(value)
+			if !v.IsValid() {
+				// Nil values set the zero value, as for fields that are not present
+				rv.Set(reflect.Zero(rv.Type()))
+				return nil
+			}
+			if v.Type() != valueType {
+				return fmt.Errorf("expected value of type %s, got %T", valueType, value)
+			}
+			rv.Set(v.Convert(rv.Type()))
+			return nil
+		},
+	}
+}
+
+// nestedFieldCodec returns a codec for nested structs, which are audited as
+// nested objects. The struct info is looked up when the codec is used rather
+// than when it is created, so that recursive types can be parsed.
+func nestedFieldCodec(t reflect.Type) structFieldCodec {
+	return structFieldCodec{
+		get: func(rv reflect.Value) (interface{}, error) {
+			return getStructInfo(t).getFields(rv)
+		},
+		set: func(rv reflect.Value, value interface{}) error {
+			fields, ok := value.([]Field)
+			if !ok {
+				return fmt.Errorf("expected nested fields, got %T", value)
+			}
+			return getStructInfo(t).setFields(rv, fields)
+		},
+	}
+}
+
+// nestedPtrFieldCodec returns a codec for pointers to nested structs. Nil
+// pointers have no nested fields, and pointers are set to nil when the nested
+// object has no fields.
+func nestedPtrFieldCodec(t reflect.Type) structFieldCodec {
+	codec := nestedFieldCodec(t)
+	return structFieldCodec{
+		get: func(rv reflect.Value) (interface{}, error) {
+			if rv.IsNil() {
+				return []Field{}, nil
+			}
+			return codec.get(rv.Elem())
+		},
+		set: func(rv reflect.Value, value interface{}) error {
+			if fields, ok := value.([]Field); ok && len(fields) == 0 {
+				rv.Set(reflect.Zero(rv.Type()))
+				return nil
+			}
+			if rv.IsNil() {
+				rv.Set(reflect.New(t))
+			}
+			return codec.set(rv.Elem(), value)
+		},
+	}
+}
+
+// sliceFieldCodec returns a codec for slices. Slices are always copied so that
+// later modifications of the struct do not alter the audit history.
+func sliceFieldCodec(valueType reflect.Type) structFieldCodec {
+	return structFieldCodec{
+		get: func(rv reflect.Value) (interface{}, error) {
+			return convertSlice(rv, valueType).Interface(), nil
+		},
+		set: func(rv reflect.Value, value interface{}) error {
+			v := reflect.ValueOf(value)
+			if !v.IsValid() {
+				// Nil values set the zero value, as for fields that are not present
+				rv.Set(reflect.Zero(rv.Type()))
+				return nil
+			}
+			if v.Type() != valueType {
+				return fmt.Errorf("expected value of type %s, got %T", valueType, value)
+			}
+			rv.Set(convertSlice(v, rv.Type()))
+			return nil
+		},
+	}
+}
+
+func convertSlice(v reflect.Value, t reflect.Type) reflect.Value {
+	if v.IsNil() {
+		return reflect.Zero(t)
+	}
+	n := v.Len()
+	s := reflect.MakeSlice(t, n, n)
+	elemType := t.Elem()
+	for i := 0; i < n; i++ {
+		s.Index(i).Set(v.Index(i).Convert(elemType))
+	}
+	return s
+}
@@ -0,0 +1,321 @@
+package audit
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type structTestStatus string
+
+type structTestObject struct {
+	Name     string           `audit:"name"`
+	Status   structTestStatus `audit:"status"`
+	Count    int              `audit:"count"`
+	Total    int64            `audit:"total"`
+	Price    float64          `audit:"price"`
+	Active   bool             `audit:"active"`
+	Tags     []string         `audit:"tags"`
+	Data     []byte           `audit:"data"`
+	Note     string           `audit:"note,omitempty"`
+	Due      time.Time        `audit:"due,omitempty"`
+	Ignored  string           `audit:"-"`
+	Untagged int
+
+	unexported string
+
+	AsOf time.Time `audit:",rollback"`
+}
+
+func TestStructGetFields(t *testing.T) {
+	obj := structTestObject{
+		Name:     "name",
+		Status:   "draft",
+		Count:    1,
+		Total:    2,
+		Price:    3.5,
+		Active:   true,
+		Tags:     []string{"a", "b"},
+		Data:     []byte{1, 2},
+		Ignored:  "ignored",
+		Untagged: 4,
+	}
+	got, tRollback, err := Struct(&obj).GetFields()
+	if err != nil {
+		t.Fatalf("GetFields() error: %v", err)
+	}
+	if !tRollback.IsZero() {
+		t.Errorf("GetFields() returned non-zero rollback time %s", tRollback)
+	}
+	want := []Field{
+		{"name", "name"},
+		{"status", "draft"},
+		{"count", 1},
+		{"total", int64(2)},
+		{"price", 3.5},
+		{"active", true},
+		{"tags", []string{"a", "b"}},
+		{"data", []byte{1, 2}},
+		{"Untagged", 4},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Wrong fields returned\nWant %v\nGot  %v", want, got)
+	}
+
+	// Slices are copied so that modifying the struct does not alter the returned fields
+	obj.Tags[0] = "modified"
+	if tags := got[6].Value.([]string); tags[0] != "a" {
+		t.Errorf("GetFields() did not copy slice value: %v", tags)
+	}
+
+	// Empty fields with omitempty are included once they are set
+	obj.Note = "note"
+	got, _, err = Struct(&obj).GetFields()
+	if err != nil {
+		t.Fatalf("GetFields() error: %v", err)
+	}
+	if field, ok := fieldSlice(got).TryGet("note"); !ok || field.Value != "note" {
+		t.Errorf("GetFields() returned wrong note field: %v (present: %v)", field, ok)
+	}
+}
+
+func TestStructAuditAndRollback(t *testing.T) {
+	getSig := new(signatureGenerator).Next
+
+	obj := &structTestObject{Name: "v1", Status: "draft", Tags: []string{"a"}}
+	var av AuditableValues
+
+	creationSignature := getSig()
+	if _, err := av.Audit(nil, Struct(obj), creationSignature); err != nil {
+		t.Fatal(err)
+	}
+	stateAtCreation := *obj
+
+	updateSignature := getSig()
+	{
+		cpy := *obj
+		obj.Name = "v2"
+		obj.Status = "published"
+		obj.Tags = []string{"a", "b"}
+		obj.Note = "added"
+		obj.Due = time.Date(2010, 1, 2, 3, 4, 5, 6, time.UTC)
+		if changed, err := av.Audit(Struct(&cpy), Struct(obj), updateSignature); err != nil {
+			t.Fatal(err)
+		} else if !changed {
+			t.Fatal("Audit() returned false on update")
+		}
+	}
+	stateAfterUpdate := *obj
+
+	tests := []struct {
+		name      string
+		tRollback time.Time
+		want      structTestObject
+	}{
+		{name: "After update", tRollback: updateSignature.Timestamp().Add(time.Second), want: stateAfterUpdate},
+		{name: "Before update", tRollback: updateSignature.Timestamp().Add(-time.Second), want: stateAtCreation},
+	}
+	for _, test := range tests {
+		if err := av.RollbackTo(Struct(obj), test.tRollback); err != nil {
+			t.Fatalf("RollbackTo(%s) error: %v", test.name, err)
+		}
+		want := test.want
+		want.AsOf = test.tRollback
+		if !reflect.DeepEqual(*obj, want) {
+			t.Fatalf("Wrong state after RollbackTo(%s)\nWant %+v\nGot  %+v", test.name, want, *obj)
+		}
+	}
+
+	// The rollback time is stored in the struct, so wrapping it again keeps track
+	// of it when rolling forward
+	tAfterUpdate := updateSignature.Timestamp().Add(time.Second)
+	if err := av.RollbackTo(Struct(obj), tAfterUpdate); err != nil {
+		t.Fatalf("RollbackTo(after update) error: %v", err)
+	}
+	want := stateAfterUpdate
+	want.AsOf = tAfterUpdate
+	if !reflect.DeepEqual(*obj, want) {
+		t.Errorf("Wrong state after rolling forward\nWant %+v\nGot  %+v", want, *obj)
+	}
+}
+
+func TestStructRollbackTimeWithoutTag(t *testing.T) {
+	var obj struct {
+		Name string
+	}
+	wrapped := Struct(&obj)
+	tRollback := time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := wrapped.SetFields([]Field{{"Name", "name"}}, tRollback); err != nil {
+		t.Fatal(err)
+	}
+	if obj.Name != "name" {
+		t.Errorf("SetFields() did not set field: %v", obj.Name)
+	}
+	if _, got, _ := wrapped.GetFields(); !got.Equal(tRollback) {
+		t.Errorf("GetFields() returned rollback time %s, want %s", got, tRollback)
+	}
+}
+
+func TestStructSetNilValues(t *testing.T) {
+	// Nil values set the zero value rather than panicking
+	obj := structTestObject{Name: "name", Count: 1, Tags: []string{"a"}, Data: []byte{1}}
+	fields := []Field{{"name", nil}, {"count", nil}, {"tags", nil}, {"data", nil}}
+	if err := Struct(&obj).SetFields(fields, time.Time{}); err != nil {
+		t.Fatalf("SetFields() error with nil values: %v", err)
+	}
+	if want := (structTestObject{}); !reflect.DeepEqual(obj, want) {
+		t.Errorf("Wrong state after SetFields() with nil values\nWant %+v\nGot  %+v", want, obj)
+	}
+}
+
+func TestStructErrors(t *testing.T) {
+	// Unsupported field types are reported as errors rather than panicking when
+	// the values are compared
+	{
+		var obj struct {
+			Values map[string]string
+		}
+		wantErr := fmt.Errorf("cannot audit field struct { Values map[string]string }.Values: unsupported type map[string]string")
+		if _, _, err := Struct(&obj).GetFields(); !gotError(wantErr, err) {
+			t.Errorf("Wrong error from GetFields()\nWant %v\nGot  %v", wantErr, err)
+		}
+		if err := Struct(&obj).SetFields(nil, time.Time{}); !gotError(wantErr, err) {
+			t.Errorf("Wrong error from SetFields()\nWant %v\nGot  %v", wantErr, err)
+		}
+	}
+
+	// Fields with values of the wrong type
+	{
+		var obj struct {
+			Name string
+		}
+		wantErr := fmt.Errorf("error setting field Name: expected value of type string, got int")
+		if err := Struct(&obj).SetFields([]Field{{"Name", 1}}, time.Time{}); !gotError(wantErr, err) {
+			t.Errorf("Wrong error from SetFields()\nWant %v\nGot  %v", wantErr, err)
+		}
+	}
+
+	// Unknown fields
+	{
+		var obj struct {
+			Name string
+		}
+		wantErr := fmt.Errorf("unknown field Other for type struct { Name string }")
+		if err := Struct(&obj).SetFields([]Field{{"Other", ""}}, time.Time{}); !gotError(wantErr, err) {
+			t.Errorf("Wrong error from SetFields()\nWant %v\nGot  %v", wantErr, err)
+		}
+	}
+
+	// Not a pointer to a struct
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("Struct() did not panic on non-pointer value")
+			}
+		}()
+		Struct(structTestObject{})
+	}()
+}
+
+type structTestAddress struct {
+	City string `audit:"city"`
+	Zip  string `audit:"zip,omitempty"`
+}
+
+type structTestCustomer struct {
+	Name     string             `audit:"name"`
+	Address  structTestAddress  `audit:"address"`
+	Billing  *structTestAddress `audit:"billing"`
+	Previous *structTestCustomer
+
+	AsOf time.Time `audit:",rollback"`
+}
+
+func TestStructNested(t *testing.T) {
+	getSig := new(signatureGenerator).Next
+
+	obj := &structTestCustomer{Name: "customer", Address: structTestAddress{City: "Oslo"}}
+	got, _, err := Struct(obj).GetFields()
+	if err != nil {
+		t.Fatalf("GetFields() error: %v", err)
+	}
+	want := []Field{
+		{"name", "customer"},
+		{"address", []Field{{"city", "Oslo"}}},
+		{"billing", []Field{}},
+		{"Previous", []Field{}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Wrong fields returned\nWant %v\nGot  %v", want, got)
+	}
+
+	var av AuditableValues
+	if _, err := av.Audit(nil, Struct(obj), getSig()); err != nil {
+		t.Fatal(err)
+	}
+	stateAtCreation := *obj
+
+	updateSignature := getSig()
+	{
+		cpy := *obj
+		obj.Address.Zip = "0150"
+		obj.Billing = &structTestAddress{City: "Bergen"}
+		if _, err := av.Audit(Struct(&cpy), Struct(obj), updateSignature); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := av.LatestSignatureForField("billing"); !got.Equal(updateSignature) {
+		t.Errorf("LatestSignatureForField(billing) = %v, want %v", got, updateSignature)
+	}
+
+	tRollback := updateSignature.Timestamp().Add(-time.Second)
+	if err := av.RollbackTo(Struct(obj), tRollback); err != nil {
+		t.Fatal(err)
+	}
+	wantObj := stateAtCreation
+	wantObj.AsOf = tRollback
+	if !reflect.DeepEqual(*obj, wantObj) {
+		t.Errorf("Wrong state after RollbackTo()\nWant %+v\nGot  %+v", wantObj, *obj)
+	}
+}
+
+func TestStructNumericTypes(t *testing.T) {
+	type level uint16
+	var obj struct {
+		Level  level    `audit:"level"`
+		Ratio  float32  `audit:"ratio"`
+		Counts []int32  `audit:"counts"`
+		Levels []level  `audit:"levels"`
+		Sizes  []uint64 `audit:"sizes"`
+	}
+	obj.Level = 3
+	obj.Ratio = 0.5
+	obj.Counts = []int32{1}
+	obj.Levels = []level{1, 2}
+	obj.Sizes = []uint64{1 << 63}
+
+	fields, _, err := Struct(&obj).GetFields()
+	if err != nil {
+		t.Fatalf("GetFields() error: %v", err)
+	}
+	want := []Field{
+		{"level", uint16(3)},
+		{"ratio", float32(0.5)},
+		{"counts", []int32{1}},
+		{"levels", []uint16{1, 2}},
+		{"sizes", []uint64{1 << 63}},
+	}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("Wrong fields returned\nWant %v\nGot  %v", want, fields)
+	}
+
+	cpy := obj
+	cpy.Level, cpy.Ratio, cpy.Counts, cpy.Levels, cpy.Sizes = 0, 0, nil, nil, nil
+	if err := Struct(&cpy).SetFields(fields, time.Time{}); err != nil {
+		t.Fatalf("SetFields() error: %v", err)
+	}
+	if !reflect.DeepEqual(cpy, obj) {
+		t.Errorf("Wrong state after SetFields()\nWant %+v\nGot  %+v", obj, cpy)
+	}
+}
@@ -0,0 +1,221 @@
+package audit
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"github.com/snechholt/bufrw"
+	"sync"
+	"time"
+)
+
+// NotLatestError is the error returned by AuditIfLatest when the latest signature of
+// the history is not the expected one, which means that someone else audited the
+// object in between.
+type NotLatestError struct {
+	Expected Signature
+	Latest   Signature
+}
+
+func (err *NotLatestError) Error() string {
+	return fmt.Sprintf("the history was audited in between (expected latest signature: %s, latest signature: %s)",
+		err.Expected, err.Latest)
+}
+
+// AuditIfLatest audits like Audit, but only if the latest signature of the history
+// equals expected, and returns a *NotLatestError otherwise. expected is the zero
+// Signature for histories that have not yet been audited. Combined with
+// SyncAuditableValues, it allows optimistic locking of objects that are read, edited
+// and audited without holding a lock in between.
+func (values *AuditableValues) AuditIfLatest(expected Signature, oldObj, newObj AuditableObject, sig Signature) (changed bool, err error) {
+	if latest := values.LatestSignature(); !latest.Equal(expected) {
+		return false, &NotLatestError{Expected: expected, Latest: latest}
+	}
+	return values.Audit(oldObj, newObj, sig)
+}
+
+// clone returns a copy of the values that does not share any mutable state with it.
+func (values *AuditableValues) clone() AuditableValues {
+	cpy := *values
+	cpy.history = append([]auditHistory(nil), values.history...)
+	cpy.checkpoints = append([]checkpoint(nil), values.checkpoints...)
+	return cpy
+}
+
+// SyncAuditableValues is an AuditableValues that is safe for concurrent use. Audits
+// and other changes to the history are serialized, while reads may run concurrently.
+// The zero value is an empty history ready to use. A SyncAuditableValues must not be
+// copied after first use.
+type SyncAuditableValues struct {
+	mu     sync.RWMutex
+	values AuditableValues
+}
+
+// NewSyncAuditableValues returns a SyncAuditableValues holding a copy of values.
+func NewSyncAuditableValues(values *AuditableValues) *SyncAuditableValues {
+	return &SyncAuditableValues{values: values.clone()}
+}
+
+// Values returns a copy of the history, which can be used and modified freely.
+func (s *SyncAuditableValues) Values() AuditableValues {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.values.clone()
+}
+
+func (s *SyncAuditableValues) Audit(oldObj, newObj AuditableObject, sig Signature) (changed bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.values.Audit(oldObj, newObj, sig)
+}
+
+// AuditIfLatest is the compare-and-swap variant of Audit. See
+// AuditableValues.AuditIfLatest.
+func (s *SyncAuditableValues) AuditIfLatest(expected Signature, oldObj, newObj AuditableObject, sig Signature) (changed bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.values.AuditIfLatest(expected, oldObj, newObj, sig)
+}
+
+func (s *SyncAuditableValues) SignLatest(key ed25519.PrivateKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.values.SignLatest(key)
+}
+
+func (s *SyncAuditableValues) Compact(current AuditableObject, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.values.Compact(current, before)
+}
+
+func (s *SyncAuditableValues) SetCheckpointInterval(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values.SetCheckpointInterval(n)
+}
+
+func (s *SyncAuditableValues) SetValueCompression(enabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values.SetValueCompression(enabled)
+}
+
+func (s *SyncAuditableValues) RollbackTo(obj AuditableObject, t time.Time) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.values.RollbackTo(obj, t)
+}
+
+func (s *SyncAuditableValues) Restore(obj AuditableObject) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.values.Restore(obj)
+}
+
+func (s *SyncAuditableValues) Changes(current AuditableObject) ([]ChangeSet, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.values.Changes(current)
+}
+
+func (s *SyncAuditableValues) FieldTimeline(current AuditableObject, name string) ([]FieldVersion, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.values.FieldTimeline(current, name)
+}
+
+func (s *SyncAuditableValues) ValueAt(current AuditableObject, name string, t time.Time) (value interface{}, existed bool, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.values.ValueAt(current, name, t)
+}
+
+func (s *SyncAuditableValues) CreationSignature() Signature {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.values.CreationSignature()
+}
+
+func (s *SyncAuditableValues) LatestSignature() Signature {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.values.LatestSignature()
+}
+
+func (s *SyncAuditableValues) Signatures() SignatureSlice {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.values.Signatures()
+}
+
+func (s *SyncAuditableValues) LatestSignatureForField(fieldName string) Signature {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.values.LatestSignatureForField(fieldName)
+}
+
+func (s *SyncAuditableValues) SignaturesForField(fieldName string) SignatureSlice {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.values.SignaturesForField(fieldName)
+}
+
+func (s *SyncAuditableValues) Query(q HistoryQuery) []HistoryMatch {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.values.Query(q)
+}
+
+func (s *SyncAuditableValues) Verify() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.values.Verify()
+}
+
+func (s *SyncAuditableValues) VerifySignatures(publicKey func(Auditor) (ed25519.PublicKey, error)) ([]UnverifiedEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.values.VerifySignatures(publicKey)
+}
+
+func (s *SyncAuditableValues) Serialize() ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.values.Serialize()
+}
+
+func (s *SyncAuditableValues) SerializeTo(w *bufrw.Writer) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.values.SerializeTo(w)
+}
+
+func (s *SyncAuditableValues) AppendSerialized(prev []byte) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.values.AppendSerialized(prev)
+}
+
+func (s *SyncAuditableValues) Deserialize(b []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.values.Deserialize(b)
+}
+
+func (s *SyncAuditableValues) DeserializeFrom(r *bufrw.Reader) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.values.DeserializeFrom(r)
+}
+
+func (s *SyncAuditableValues) MarshalJSON() ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.values.MarshalJSON()
+}
+
+func (s *SyncAuditableValues) UnmarshalJSON(b []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.values.UnmarshalJSON(b)
+}
@@ -0,0 +1,147 @@
+package audit
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestAuditableValuesAuditIfLatest(t *testing.T) {
+	getSig := new(signatureGenerator).Next
+
+	obj := &auditableObject{Values: map[string]interface{}{"A": 0}}
+	var av AuditableValues
+	if _, err := av.AuditIfLatest(Signature{}, nil, obj, getSig()); err != nil {
+		t.Fatalf("AuditIfLatest() error on creation: %v", err)
+	}
+	latest := av.LatestSignature()
+
+	cpy := obj.Copy()
+	obj.Values["A"] = 1
+	if changed, err := av.AuditIfLatest(latest, cpy, obj, getSig()); err != nil || !changed {
+		t.Fatalf("AuditIfLatest() = %v, %v, want true, nil", changed, err)
+	}
+
+	// Auditing based on the previous signature fails
+	cpy = obj.Copy()
+	obj.Values["A"] = 2
+	wantErr := &NotLatestError{Expected: latest, Latest: av.LatestSignature()}
+	if _, err := av.AuditIfLatest(latest, cpy, obj, getSig()); !reflect.DeepEqual(err, wantErr) {
+		t.Errorf("Wrong error from AuditIfLatest() with stale signature\nWant %v\nGot  %v", wantErr, err)
+	}
+	if n := len(av.history); n != 2 {
+		t.Errorf("AuditIfLatest() with stale signature modified the history")
+	}
+}
+
+func TestSyncAuditableValuesConcurrentAudits(t *testing.T) {
+	var mu sync.Mutex
+	gen := new(signatureGenerator)
+	getSig := func() Signature {
+		mu.Lock()
+		defer mu.Unlock()
+		return gen.Next()
+	}
+
+	var s SyncAuditableValues
+	if _, err := s.Audit(nil, &auditableObject{Values: map[string]interface{}{"n": 0}}, getSig()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each goroutine increments n, retrying until no one else audited in between
+	const goroutines, increments = 8, 10
+	var wg sync.WaitGroup
+	var conflicts int
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < increments; {
+				values := s.Values()
+				n := len(values.Signatures()) - 1
+				oldObj := &auditableObject{Values: map[string]interface{}{"n": n}}
+				newObj := &auditableObject{Values: map[string]interface{}{"n": n + 1}}
+				_, err := s.AuditIfLatest(values.LatestSignature(), oldObj, newObj, getSig())
+				var notLatest *NotLatestError
+				switch {
+				case errors.As(err, &notLatest):
+					mu.Lock()
+					conflicts++
+					mu.Unlock()
+				case err != nil:
+					t.Error(err)
+					return
+				default:
+					j++
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	values := s.Values()
+	obj := &auditableObject{}
+	if err := values.Restore(obj); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := obj.Values["n"], goroutines*increments; got != want {
+		t.Errorf("Wrong value after concurrent audits: %v, want %d (%d conflicts)", got, want, conflicts)
+	}
+	if err := s.Verify(); err != nil {
+		t.Errorf("Verify() error after concurrent audits: %v", err)
+	}
+
+	// Modifying the returned copy does not affect the history
+	values.history[0].signature = Signature{}
+	if s.CreationSignature().IsZero() {
+		t.Errorf("Modifying the values returned by Values() modified the history")
+	}
+}
+
+func TestSyncAuditableValuesReads(t *testing.T) {
+	getSig := new(signatureGenerator).Next
+
+	obj := &auditableObject{Values: map[string]interface{}{"A": 0}}
+	var av AuditableValues
+	if _, err := av.Audit(nil, obj, getSig()); err != nil {
+		t.Fatal(err)
+	}
+	cpy := obj.Copy()
+	obj.Values = map[string]interface{}{"A": 1, "B": 1}
+	if _, err := av.Audit(cpy, obj, getSig()); err != nil {
+		t.Fatal(err)
+	}
+	s := NewSyncAuditableValues(&av)
+
+	// The reads return the same as those of the wrapped values
+	if got, want := s.LatestSignatureForField("B"), av.LatestSignatureForField("B"); !got.Equal(want) {
+		t.Errorf("LatestSignatureForField() = %s, want %s", got, want)
+	}
+	if got, want := s.SignaturesForField("A"), av.SignaturesForField("A"); !reflect.DeepEqual(got, want) {
+		t.Errorf("SignaturesForField()\nWant %v\nGot  %v", want, got)
+	}
+	q := HistoryQuery{Fields: []string{"B"}}
+	if got, want := s.Query(q), av.Query(q); !reflect.DeepEqual(got, want) {
+		t.Errorf("Query()\nWant %v\nGot  %v", want, got)
+	}
+	unverified, err := s.VerifySignatures(func(Auditor) (ed25519.PublicKey, error) { return nil, nil })
+	if err != nil || len(unverified) != 2 {
+		t.Errorf("VerifySignatures() = %v, %v, want two unsigned entries", unverified, err)
+	}
+
+	// JSON round trip
+	b, err := json.Marshal(s)
+	if err != nil {
+		t.Fatalf("json.Marshal() error: %v", err)
+	}
+	var got SyncAuditableValues
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("json.Unmarshal() error: %v", err)
+	}
+	if values := got.Values(); !reflect.DeepEqual(values.Signatures(), av.Signatures()) {
+		t.Errorf("Wrong signatures after JSON round trip\nWant %v\nGot  %v", av.Signatures(), values.Signatures())
+	}
+}
@@ -1,93 +1,112 @@
 package audit
 
-import "fmt"
+import (
+	"fmt"
+	"reflect"
+	"time"
+)
 
+// equals reports whether two field values are equal. Values of registered value
+// types are compared with the Equal function of their codec.
 func equals(value1, value2 interface{}) bool {
 	switch v1 := value1.(type) {
+	case magicValue:
+		return equalValues(v1, value2)
 	case string:
-		v2, ok := value2.(string)
-		return ok && v1 == v2
+		return equalValues(v1, value2)
 	case bool:
-		v2, ok := value2.(bool)
-		return ok && v1 == v2
+		return equalValues(v1, value2)
 	case int:
-		v2, ok := value2.(int)
-		return ok && v1 == v2
+		return equalValues(v1, value2)
+	case int8:
+		return equalValues(v1, value2)
+	case int16:
+		return equalValues(v1, value2)
+	case int32:
+		return equalValues(v1, value2)
 	case int64:
-		v2, ok := value2.(int64)
-		return ok && v1 == v2
+		return equalValues(v1, value2)
+	case uint:
+		return equalValues(v1, value2)
+	case uint8:
+		return equalValues(v1, value2)
+	case uint16:
+		return equalValues(v1, value2)
+	case uint32:
+		return equalValues(v1, value2)
+	case uint64:
+		return equalValues(v1, value2)
+	case float32:
+		return equalValues(v1, value2)
 	case float64:
-		v2, ok := value2.(float64)
-		return ok && v1 == v2
+		return equalValues(v1, value2)
 	case []string:
-		v2, ok := value2.([]string)
-		if !ok || len(v1) != len(v2) {
-			return false
-		}
-		for i := range v1 {
-			if v1[i] != v2[i] {
-				return false
-			}
-		}
-		return true
+		return equalSlices(v1, value2)
 	case []bool:
-		v2, ok := value2.([]bool)
-		if !ok || len(v1) != len(v2) {
-			return false
-		}
-		for i := range v1 {
-			if v1[i] != v2[i] {
-				return false
-			}
-		}
-		return true
+		return equalSlices(v1, value2)
 	case []int:
-		v2, ok := value2.([]int)
-		if !ok || len(v1) != len(v2) {
-			return false
-		}
-		for i := range v1 {
-			if v1[i] != v2[i] {
-				return false
-			}
-		}
-		return true
+		return equalSlices(v1, value2)
+	case []int8:
+		return equalSlices(v1, value2)
+	case []int16:
+		return equalSlices(v1, value2)
+	case []int32:
+		return equalSlices(v1, value2)
 	case []int64:
-		v2, ok := value2.([]int64)
-		if !ok || len(v1) != len(v2) {
-			return false
-		}
-		for i := range v1 {
-			if v1[i] != v2[i] {
-				return false
-			}
-		}
-		return true
+		return equalSlices(v1, value2)
+	case []uint:
+		return equalSlices(v1, value2)
+	case []uint16:
+		return equalSlices(v1, value2)
+	case []uint32:
+		return equalSlices(v1, value2)
+	case []uint64:
+		return equalSlices(v1, value2)
+	case []float32:
+		return equalSlices(v1, value2)
 	case []float64:
-		v2, ok := value2.([]float64)
-		if !ok || len(v1) != len(v2) {
-			return false
-		}
-		for i := range v1 {
-			if v1[i] != v2[i] {
-				return false
-			}
-		}
-		return true
+		return equalSlices(v1, value2)
 	case []byte:
-		v2, ok := value2.([]byte)
+		return equalSlices(v1, value2)
+	case time.Time:
+		v2, ok := value2.(time.Time)
+		return ok && v1.Equal(v2)
+	case []time.Time:
+		v2, ok := value2.([]time.Time)
 		if !ok || len(v1) != len(v2) {
 			return false
 		}
 		for i := range v1 {
-			if v1[i] != v2[i] {
+			if !v1[i].Equal(v2[i]) {
 				return false
 			}
 		}
 		return true
 	default:
-		panic(fmt.Sprintf("Unsupported value type: %T", value1))
+		vt, ok := lookupValueType(value1)
+		if !ok {
+			panic(fmt.Sprintf("Unsupported value type: %T", value1))
+		}
+		return reflect.TypeOf(value2) == vt.typ && vt.codec.Equal(value1, value2)
+	}
+}
+
+func equalValues[T comparable](v1 T, value2 interface{}) bool {
+	v2, ok := value2.(T)
+	return ok && v1 == v2
+}
+
+func equalSlices[T comparable](v1 []T, value2 interface{}) bool {
+	v2, ok := value2.([]T)
+	if !ok || len(v1) != len(v2) {
+		return false
+	}
+	for i := range v1 {
+		if v1[i] != v2[i] {
+			return false
+		}
 	}
+	return true
 }
 
 type stringSlice []string
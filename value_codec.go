@@ -0,0 +1,558 @@
+package audit
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/snechholt/bufrw"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Type tags written in front of each serialized field value. The values are
+// part of the serialization format and must never change.
+const (
+	valueTypeMagic      byte = 0
+	valueTypeString     byte = 1
+	valueTypeBool       byte = 2
+	valueTypeInt        byte = 3
+	valueTypeInt64      byte = 4
+	valueTypeFloat64    byte = 5
+	valueTypeStrings    byte = 6
+	valueTypeBools      byte = 7
+	valueTypeInts       byte = 8
+	valueTypeInt64s     byte = 9
+	valueTypeFloat64s   byte = 10
+	valueTypeBytes      byte = 11
+	valueTypeTime       byte = 12
+	valueTypeTimes      byte = 13
+	valueTypeInt8       byte = 14
+	valueTypeInt16      byte = 15
+	valueTypeInt32      byte = 16
+	valueTypeUint       byte = 17
+	valueTypeUint8      byte = 18
+	valueTypeUint16     byte = 19
+	valueTypeUint32     byte = 20
+	valueTypeUint64     byte = 21
+	valueTypeFloat32    byte = 22
+	valueTypeInt8s      byte = 23
+	valueTypeInt16s     byte = 24
+	valueTypeInt32s     byte = 25
+	valueTypeUints      byte = 26
+	valueTypeUint16s    byte = 27
+	valueTypeUint32s    byte = 28
+	valueTypeUint64s    byte = 29
+	valueTypeFloat32s   byte = 30
+	valueTypeRegistered byte = 255
+)
+
+var builtinValueTypes = map[reflect.Type]bool{
+	reflect.TypeOf(""):            true,
+	reflect.TypeOf(false):         true,
+	reflect.TypeOf(int(0)):        true,
+	reflect.TypeOf(int8(0)):       true,
+	reflect.TypeOf(int16(0)):      true,
+	reflect.TypeOf(int32(0)):      true,
+	reflect.TypeOf(int64(0)):      true,
+	reflect.TypeOf(uint(0)):       true,
+	reflect.TypeOf(uint8(0)):      true,
+	reflect.TypeOf(uint16(0)):     true,
+	reflect.TypeOf(uint32(0)):     true,
+	reflect.TypeOf(uint64(0)):     true,
+	reflect.TypeOf(float32(0)):    true,
+	reflect.TypeOf(float64(0)):    true,
+	reflect.TypeOf([]string{}):    true,
+	reflect.TypeOf([]bool{}):      true,
+	reflect.TypeOf([]int{}):       true,
+	reflect.TypeOf([]int8{}):      true,
+	reflect.TypeOf([]int16{}):     true,
+	reflect.TypeOf([]int32{}):     true,
+	reflect.TypeOf([]int64{}):     true,
+	reflect.TypeOf([]uint{}):      true,
+	reflect.TypeOf([]uint16{}):    true,
+	reflect.TypeOf([]uint32{}):    true,
+	reflect.TypeOf([]uint64{}):    true,
+	reflect.TypeOf([]float32{}):   true,
+	reflect.TypeOf([]float64{}):   true,
+	reflect.TypeOf([]byte{}):      true,
+	reflect.TypeOf(time.Time{}):   true,
+	reflect.TypeOf([]time.Time{}): true,
+}
+
+func isBuiltinValue(value interface{}) bool {
+	return builtinValueTypes[reflect.TypeOf(value)]
+}
+
+// checkValueType returns an error if value is not of a built-in or registered
+// value type.
+func checkValueType(value interface{}) error {
+	if isBuiltinValue(value) {
+		return nil
+	}
+	if _, ok := lookupValueType(value); ok {
+		return nil
+	}
+	return fmt.Errorf("unsupported value type %T", value)
+}
+
+func writeValue(w *bufrw.Writer, value interface{}) error {
+	var err error
+	switch v := value.(type) {
+	case magicValue:
+		if err = w.WriteByteValue(valueTypeMagic); err == nil {
+			err = w.WriteByteValue(byte(v))
+		}
+	case string:
+		if err = w.WriteByteValue(valueTypeString); err == nil {
+			err = w.WriteString(v)
+		}
+	case bool:
+		if err = w.WriteByteValue(valueTypeBool); err == nil {
+			err = w.WriteBool(v)
+		}
+	case int:
+		if err = w.WriteByteValue(valueTypeInt); err == nil {
+			err = w.WriteInt(v)
+		}
+	case int64:
+		if err = w.WriteByteValue(valueTypeInt64); err == nil {
+			err = w.WriteInt64(v)
+		}
+	case float64:
+		if err = w.WriteByteValue(valueTypeFloat64); err == nil {
+			err = w.WriteFloat64(v)
+		}
+	case []string:
+		if err = w.WriteByteValue(valueTypeStrings); err == nil {
+			err = w.WriteStrings(v...)
+		}
+	case []bool:
+		if err = w.WriteByteValue(valueTypeBools); err == nil {
+			err = w.WriteBools(v...)
+		}
+	case []int:
+		if err = w.WriteByteValue(valueTypeInts); err == nil {
+			err = w.WriteInts(v...)
+		}
+	case []int64:
+		if err = w.WriteByteValue(valueTypeInt64s); err == nil {
+			err = w.WriteInt64s(v...)
+		}
+	case []float64:
+		if err = w.WriteByteValue(valueTypeFloat64s); err == nil {
+			err = w.WriteFloat64s(v...)
+		}
+	case []byte:
+		if err = w.WriteByteValue(valueTypeBytes); err == nil {
+			err = w.WriteByteValues(v...)
+		}
+	case time.Time:
+		if err = w.WriteByteValue(valueTypeTime); err == nil {
+			err = writeTime(w, v)
+		}
+	case []time.Time:
+		if err = w.WriteByteValue(valueTypeTimes); err == nil {
+			err = writeSlice(w, v, func(t time.Time) error { return writeTime(w, t) })
+		}
+	case int8:
+		if err = w.WriteByteValue(valueTypeInt8); err == nil {
+			err = w.WriteInt(int(v))
+		}
+	case int16:
+		if err = w.WriteByteValue(valueTypeInt16); err == nil {
+			err = w.WriteInt(int(v))
+		}
+	case int32:
+		if err = w.WriteByteValue(valueTypeInt32); err == nil {
+			err = w.WriteInt(int(v))
+		}
+	case uint:
+		if err = w.WriteByteValue(valueTypeUint); err == nil {
+			err = w.WriteInt64(int64(v))
+		}
+	case uint8:
+		if err = w.WriteByteValue(valueTypeUint8); err == nil {
+			err = w.WriteByteValue(v)
+		}
+	case uint16:
+		if err = w.WriteByteValue(valueTypeUint16); err == nil {
+			err = w.WriteInt(int(v))
+		}
+	case uint32:
+		if err = w.WriteByteValue(valueTypeUint32); err == nil {
+			err = w.WriteInt64(int64(v))
+		}
+	case uint64:
+		if err = w.WriteByteValue(valueTypeUint64); err == nil {
+			err = w.WriteInt64(int64(v))
+		}
+	case float32:
+		if err = w.WriteByteValue(valueTypeFloat32); err == nil {
+			err = w.WriteFloat64(float64(v))
+		}
+	case []int8:
+		if err = w.WriteByteValue(valueTypeInt8s); err == nil {
+			err = writeSlice(w, v, func(v int8) error { return w.WriteInt(int(v)) })
+		}
+	case []int16:
+		if err = w.WriteByteValue(valueTypeInt16s); err == nil {
+			err = writeSlice(w, v, func(v int16) error { return w.WriteInt(int(v)) })
+		}
+	case []int32:
+		if err = w.WriteByteValue(valueTypeInt32s); err == nil {
+			err = writeSlice(w, v, func(v int32) error { return w.WriteInt(int(v)) })
+		}
+	case []uint:
+		if err = w.WriteByteValue(valueTypeUints); err == nil {
+			err = writeSlice(w, v, func(v uint) error { return w.WriteInt64(int64(v)) })
+		}
+	case []uint16:
+		if err = w.WriteByteValue(valueTypeUint16s); err == nil {
+			err = writeSlice(w, v, func(v uint16) error { return w.WriteInt(int(v)) })
+		}
+	case []uint32:
+		if err = w.WriteByteValue(valueTypeUint32s); err == nil {
+			err = writeSlice(w, v, func(v uint32) error { return w.WriteInt64(int64(v)) })
+		}
+	case []uint64:
+		if err = w.WriteByteValue(valueTypeUint64s); err == nil {
+			err = writeSlice(w, v, func(v uint64) error { return w.WriteInt64(int64(v)) })
+		}
+	case []float32:
+		if err = w.WriteByteValue(valueTypeFloat32s); err == nil {
+			err = writeSlice(w, v, func(v float32) error { return w.WriteFloat64(float64(v)) })
+		}
+	default:
+		vt, ok := lookupValueType(value)
+		if !ok {
+			return fmt.Errorf("cannot serialize value of type %T", value)
+		}
+		if err = w.WriteByteValue(valueTypeRegistered); err == nil {
+			err = vt.write(w, value)
+		}
+	}
+	return err
+}
+
+func readValue(r *bufrw.Reader) (interface{}, error) {
+	valueType, err := r.ReadByteValue()
+	if err != nil {
+		return nil, err
+	}
+	switch valueType {
+	case valueTypeMagic:
+		v, err := r.ReadByteValue()
+		if err != nil {
+			return nil, err
+		}
+		return magicValue(v), nil
+	case valueTypeString:
+		return r.ReadString()
+	case valueTypeBool:
+		return r.ReadBool()
+	case valueTypeInt:
+		return r.ReadInt()
+	case valueTypeInt64:
+		return r.ReadInt64()
+	case valueTypeFloat64:
+		return r.ReadFloat64()
+	case valueTypeStrings:
+		return r.ReadStrings()
+	case valueTypeBools:
+		return r.ReadBools()
+	case valueTypeInts:
+		return r.ReadInts()
+	case valueTypeInt64s:
+		return r.ReadInt64s()
+	case valueTypeFloat64s:
+		return r.ReadFloat64s()
+	case valueTypeBytes:
+		return r.ReadByteValues()
+	case valueTypeTime:
+		return readTime(r)
+	case valueTypeTimes:
+		return readSlice(r, func() (time.Time, error) { return readTime(r) })
+	case valueTypeInt8:
+		v, err := r.ReadInt()
+		return int8(v), err
+	case valueTypeInt16:
+		v, err := r.ReadInt()
+		return int16(v), err
+	case valueTypeInt32:
+		v, err := r.ReadInt()
+		return int32(v), err
+	case valueTypeUint:
+		v, err := r.ReadInt64()
+		return uint(v), err
+	case valueTypeUint8:
+		return r.ReadByteValue()
+	case valueTypeUint16:
+		v, err := r.ReadInt()
+		return uint16(v), err
+	case valueTypeUint32:
+		v, err := r.ReadInt64()
+		return uint32(v), err
+	case valueTypeUint64:
+		v, err := r.ReadInt64()
+		return uint64(v), err
+	case valueTypeFloat32:
+		v, err := r.ReadFloat64()
+		return float32(v), err
+	case valueTypeInt8s:
+		return readSlice(r, func() (int8, error) { v, err := r.ReadInt(); return int8(v), err })
+	case valueTypeInt16s:
+		return readSlice(r, func() (int16, error) { v, err := r.ReadInt(); return int16(v), err })
+	case valueTypeInt32s:
+		return readSlice(r, func() (int32, error) { v, err := r.ReadInt(); return int32(v), err })
+	case valueTypeUints:
+		return readSlice(r, func() (uint, error) { v, err := r.ReadInt64(); return uint(v), err })
+	case valueTypeUint16s:
+		return readSlice(r, func() (uint16, error) { v, err := r.ReadInt(); return uint16(v), err })
+	case valueTypeUint32s:
+		return readSlice(r, func() (uint32, error) { v, err := r.ReadInt64(); return uint32(v), err })
+	case valueTypeUint64s:
+		return readSlice(r, func() (uint64, error) { v, err := r.ReadInt64(); return uint64(v), err })
+	case valueTypeFloat32s:
+		return readSlice(r, func() (float32, error) { v, err := r.ReadFloat64(); return float32(v), err })
+	case valueTypeRegistered:
+		return readRegisteredValue(r)
+	default:
+		return nil, fmt.Errorf("invalid value type: %d", valueType)
+	}
+}
+
+// ValueCodec describes how values of a custom field value type are compared and
+// serialized. Custom types are registered with RegisterValueType.
+type ValueCodec struct {
+	// Equal reports whether two values of the type are equal. It is used when
+	// auditing to determine whether a field has changed.
+	Equal func(a, b interface{}) bool
+
+	// Encode writes a value of the type to w.
+	Encode func(w *bufrw.Writer, value interface{}) error
+
+	// Decode reads a value written by Encode from r.
+	Decode func(r *bufrw.Reader) (interface{}, error)
+}
+
+type registeredValueType struct {
+	id    int
+	typ   reflect.Type
+	codec ValueCodec
+}
+
+var valueTypeRegistry = struct {
+	sync.RWMutex
+	byType map[reflect.Type]*registeredValueType
+	byID   map[int]*registeredValueType
+}{
+	byType: make(map[reflect.Type]*registeredValueType),
+	byID:   make(map[int]*registeredValueType),
+}
+
+// RegisterValueType registers a custom field value type, so that fields with
+// values of the same type as sample can be audited and serialized. The id is
+// written to the serialized history in front of each value of the type, and
+// must stay the same for as long as serialized histories are kept. The id must
+// be in the range [1, math.MaxInt32].
+//
+// RegisterValueType is meant to be called during initialization, and panics if
+// the type or id is already registered, if the type is one of the built-in
+// value types or if any of the codec functions are nil.
+func RegisterValueType(id int, sample interface{}, codec ValueCodec) {
+	if id < 1 || int64(id) > 1<<31-1 {
+		panic(fmt.Sprintf("audit.RegisterValueType: invalid id %d", id))
+	}
+	if codec.Equal == nil || codec.Encode == nil || codec.Decode == nil {
+		panic("audit.RegisterValueType: codec functions must not be nil")
+	}
+	if sample == nil || isBuiltinValue(sample) {
+		panic(fmt.Sprintf("audit.RegisterValueType: cannot register built-in value type %T", sample))
+	}
+	typ := reflect.TypeOf(sample)
+
+	valueTypeRegistry.Lock()
+	defer valueTypeRegistry.Unlock()
+	if _, ok := valueTypeRegistry.byType[typ]; ok {
+		panic(fmt.Sprintf("audit.RegisterValueType: type %s is already registered", typ))
+	}
+	if vt, ok := valueTypeRegistry.byID[id]; ok {
+		panic(fmt.Sprintf("audit.RegisterValueType: id %d is already registered for type %s", id, vt.typ))
+	}
+	vt := &registeredValueType{id: id, typ: typ, codec: codec}
+	valueTypeRegistry.byType[typ] = vt
+	valueTypeRegistry.byID[id] = vt
+}
+
+func lookupValueType(value interface{}) (*registeredValueType, bool) {
+	return lookupValueTypeOf(reflect.TypeOf(value))
+}
+
+func lookupValueTypeOf(typ reflect.Type) (*registeredValueType, bool) {
+	valueTypeRegistry.RLock()
+	defer valueTypeRegistry.RUnlock()
+	vt, ok := valueTypeRegistry.byType[typ]
+	return vt, ok
+}
+
+func lookupValueTypeByID(id int) (*registeredValueType, bool) {
+	valueTypeRegistry.RLock()
+	defer valueTypeRegistry.RUnlock()
+	vt, ok := valueTypeRegistry.byID[id]
+	return vt, ok
+}
+
+// encode returns the value encoded by the codec of the type.
+func (vt *registeredValueType) encode(value interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	var buf bufrw.Buffer
+	if err := vt.codec.Encode(buf.Writer(&b), value); err != nil {
+		return nil, fmt.Errorf("error encoding value of type %s: %w", vt.typ, err)
+	}
+	return b.Bytes(), nil
+}
+
+// decode returns the value decoded by the codec of the type.
+func (vt *registeredValueType) decode(b []byte) (interface{}, error) {
+	var buf bufrw.Buffer
+	value, err := vt.codec.Decode(buf.Reader(bytes.NewReader(b)))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding value of type %s: %w", vt.typ, err)
+	}
+	return value, nil
+}
+
+// write writes the id of the type and the encoded value. The encoded value is
+// length prefixed so that a faulty codec cannot corrupt the rest of the stream.
+func (vt *registeredValueType) write(w *bufrw.Writer, value interface{}) error {
+	b, err := vt.encode(value)
+	if err != nil {
+		return err
+	}
+	if err := w.WriteInt(vt.id); err != nil {
+		return err
+	}
+	return w.WriteByteValues(b...)
+}
+
+func readRegisteredValue(r *bufrw.Reader) (interface{}, error) {
+	id, err := r.ReadInt()
+	if err != nil {
+		return nil, err
+	}
+	b, err := r.ReadByteValues()
+	if err != nil {
+		return nil, err
+	}
+	vt, ok := lookupValueTypeByID(id)
+	if !ok {
+		return nil, fmt.Errorf("invalid value type: no type registered with id %d", id)
+	}
+	return vt.decode(b)
+}
+
+// writeTime writes t with nanosecond precision, along with the name of its
+// location and the zone abbreviation and offset in effect at t.
+func writeTime(w *bufrw.Writer, t time.Time) error {
+	name, offset := t.Zone()
+	if err := w.WriteInt64(t.Unix()); err != nil {
+		return err
+	}
+	if err := w.WriteInt(t.Nanosecond()); err != nil {
+		return err
+	}
+	if err := w.WriteString(t.Location().String()); err != nil {
+		return err
+	}
+	if err := w.WriteString(name); err != nil {
+		return err
+	}
+	return w.WriteInt(offset)
+}
+
+// readTime reads a time written by writeTime. See restoreLocation for how the
+// location of the time is restored.
+func readTime(r *bufrw.Reader) (time.Time, error) {
+	sec, err := r.ReadInt64()
+	if err != nil {
+		return time.Time{}, err
+	}
+	nsec, err := r.ReadInt()
+	if err != nil {
+		return time.Time{}, err
+	}
+	locName, err := r.ReadString()
+	if err != nil {
+		return time.Time{}, err
+	}
+	zoneName, err := r.ReadString()
+	if err != nil {
+		return time.Time{}, err
+	}
+	offset, err := r.ReadInt()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return restoreLocation(time.Unix(sec, int64(nsec)), locName, zoneName, offset), nil
+}
+
+// restoreLocation returns t in its original location if that location is available
+// and has the same offset at the time, and in a fixed zone with the original
+// abbreviation and offset if not.
+func restoreLocation(t time.Time, locName, zoneName string, offset int) time.Time {
+	if locName == "UTC" && offset == 0 {
+		return t.In(time.UTC)
+	}
+	if loc := loadLocation(locName); loc != nil {
+		if _, locOffset := t.In(loc).Zone(); locOffset == offset {
+			return t.In(loc)
+		}
+	}
+	return t.In(time.FixedZone(zoneName, offset))
+}
+
+// locations caches the locations loaded by loadLocation, since time.LoadLocation
+// reads the time zone database every time it is called.
+var locations sync.Map // map[string]*time.Location
+
+// loadLocation returns the location with the given name, or nil if it cannot be
+// loaded. Names that cannot be loaded are cached as well.
+func loadLocation(name string) *time.Location {
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		loc = nil
+	}
+	locations.Store(name, loc)
+	return loc
+}
+
+// writeSlice writes the length of values followed by each value written with write.
+func writeSlice[T any](w *bufrw.Writer, values []T, write func(T) error) error {
+	if err := w.WriteInt(len(values)); err != nil {
+		return err
+	}
+	for _, v := range values {
+		if err := write(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readSlice reads a slice written by writeSlice, reading each value with read.
+func readSlice[T any](r *bufrw.Reader, read func() (T, error)) ([]T, error) {
+	n, err := r.ReadInt()
+	if err != nil {
+		return nil, err
+	}
+	values := make([]T, n)
+	for i := range values {
+		if values[i], err = read(); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
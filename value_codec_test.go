@@ -0,0 +1,169 @@
+package audit
+
+import (
+	"fmt"
+	"github.com/snechholt/bufrw"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testMoney is a custom value type registered for the tests in this file
+type testMoney struct {
+	Currency string
+	Cents    int64
+}
+
+func init() {
+	RegisterValueType(1000, testMoney{}, ValueCodec{
+		Equal: func(a, b interface{}) bool {
+			return a.(testMoney) == b.(testMoney)
+		},
+		Encode: func(w *bufrw.Writer, value interface{}) error {
+			m := value.(testMoney)
+			if err := w.WriteString(m.Currency); err != nil {
+				return err
+			}
+			return w.WriteInt64(m.Cents)
+		},
+		Decode: func(r *bufrw.Reader) (interface{}, error) {
+			var m testMoney
+			var err error
+			if m.Currency, err = r.ReadString(); err != nil {
+				return nil, err
+			}
+			if m.Cents, err = r.ReadInt64(); err != nil {
+				return nil, err
+			}
+			return m, nil
+		},
+	})
+}
+
+func TestRegisteredValueTypeAuditAndSerialize(t *testing.T) {
+	getSig := new(signatureGenerator).Next
+
+	obj := &auditableObject{Values: map[string]interface{}{
+		"price": testMoney{"NOK", 1000},
+	}}
+	var av AuditableValues
+	if _, err := av.Audit(nil, obj, getSig()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Equal values are not audited
+	if changed, err := av.Audit(obj.Copy(), obj, getSig()); err != nil {
+		t.Fatal(err)
+	} else if changed {
+		t.Errorf("Audit() returned true when registered value was not changed")
+	}
+
+	cpy := obj.Copy()
+	obj.Values["price"] = testMoney{"NOK", 1200}
+	if changed, err := av.Audit(cpy, obj, getSig()); err != nil {
+		t.Fatal(err)
+	} else if !changed {
+		t.Errorf("Audit() returned false when registered value was changed")
+	}
+
+	b, err := av.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() error: %v", err)
+	}
+	var got AuditableValues
+	if err := got.Deserialize(b); err != nil {
+		t.Fatalf("Deserialize() error: %v", err)
+	}
+	if !reflect.DeepEqual(av, got) {
+		t.Errorf("Wrong value after serialize/deserialize\nWant %v\nGot  %v", av, got)
+	}
+}
+
+func TestRegisteredValueTypeStruct(t *testing.T) {
+	var obj struct {
+		Price testMoney `audit:"price"`
+	}
+	obj.Price = testMoney{"NOK", 1}
+	fields, _, err := Struct(&obj).GetFields()
+	if err != nil {
+		t.Fatalf("GetFields() error: %v", err)
+	}
+	want := []Field{{"price", testMoney{"NOK", 1}}}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("Wrong fields returned\nWant %v\nGot  %v", want, fields)
+	}
+}
+
+func TestUnsupportedValueType(t *testing.T) {
+	type unsupported struct{}
+
+	var av AuditableValues
+	obj := &auditableObject{Values: map[string]interface{}{"field": unsupported{}}}
+	wantErr := fmt.Errorf("cannot audit field field: unsupported value type audit.unsupported")
+	if _, err := av.Audit(nil, obj, new(signatureGenerator).Next()); !gotError(wantErr, err) {
+		t.Errorf("Wrong error returned when auditing unsupported value\nWant %v\nGot  %v", wantErr, err)
+	}
+
+	av.history = append(av.history, auditHistory{
+		fields:    fieldSlice{{"field", unsupported{}}},
+		signature: new(signatureGenerator).Next(),
+	})
+	wantErr = fmt.Errorf("cannot serialize value of type audit.unsupported")
+	if _, err := av.Serialize(); !gotError(wantErr, err) {
+		t.Errorf("Wrong error returned when serializing unsupported value\nWant %v\nGot  %v", wantErr, err)
+	}
+}
+
+func TestRegisterValueTypePanics(t *testing.T) {
+	codec := ValueCodec{
+		Equal:  func(a, b interface{}) bool { return a == b },
+		Encode: func(w *bufrw.Writer, value interface{}) error { return nil },
+		Decode: func(r *bufrw.Reader) (interface{}, error) { return nil, nil },
+	}
+	type other struct{}
+	tests := []struct {
+		name   string
+		id     int
+		sample interface{}
+		codec  ValueCodec
+		want   string
+	}{
+		{"duplicate type", 1001, testMoney{}, codec, "already registered"},
+		{"duplicate id", 1000, other{}, codec, "already registered"},
+		{"built-in type", 1001, "", codec, "built-in"},
+		{"invalid id", 0, other{}, codec, "invalid id"},
+		{"nil codec", 1001, other{}, ValueCodec{}, "must not be nil"},
+	}
+	for _, test := range tests {
+		func() {
+			defer func() {
+				r := recover()
+				if r == nil || !strings.Contains(fmt.Sprint(r), test.want) {
+					t.Errorf("RegisterValueType(%s) panicked with %v, want %q", test.name, r, test.want)
+				}
+			}()
+			RegisterValueType(test.id, test.sample, test.codec)
+		}()
+	}
+}
+
+func TestLoadLocation(t *testing.T) {
+	if _, err := time.LoadLocation("Europe/Oslo"); err != nil {
+		t.Skipf("Time zone database not available: %v", err)
+	}
+	// Loaded locations are cached, so the same location is returned every time
+	loc := loadLocation("Europe/Oslo")
+	if loc == nil || loc.String() != "Europe/Oslo" {
+		t.Fatalf("loadLocation(Europe/Oslo) = %v", loc)
+	}
+	if again := loadLocation("Europe/Oslo"); again != loc {
+		t.Errorf("loadLocation(Europe/Oslo) did not return the cached location")
+	}
+	if loc := loadLocation("Custom/Zone"); loc != nil {
+		t.Errorf("loadLocation(Custom/Zone) = %v, want nil", loc)
+	}
+	if _, ok := locations.Load("Custom/Zone"); !ok {
+		t.Errorf("loadLocation(Custom/Zone) did not cache the missing location")
+	}
+}